@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"./service"
+)
+
+var inferUsageStr = `
+Usage: rest2res infer [options] <url|file>
+
+Fetches a sample response from the URL, or reads it from the file, and
+prints a proposed endpoint configuration.
+
+Infer Options:
+    -u, --url <url>                  Endpoint URL, with ${tags}, to use in the config
+    -f, --format <format>            Response format: json, xml, csv, or yaml
+    -r, --root <path>                Path to the data within the response
+
+Common Options:
+    -h, --help                       Show this message
+`
+
+// inferTimeout is the timeout for fetching a sample response.
+var inferTimeout = 30 * time.Second
+
+func inferUsage() {
+	fmt.Printf("%s\n", inferUsageStr)
+	os.Exit(0)
+}
+
+// infer runs the infer command.
+func infer(args []string) {
+	fs := flag.NewFlagSet("infer", flag.ExitOnError)
+	fs.Usage = inferUsage
+
+	var (
+		showHelp bool
+		rawurl   string
+		format   string
+		root     string
+	)
+	fs.BoolVar(&showHelp, "h", false, "Show this message.")
+	fs.BoolVar(&showHelp, "help", false, "Show this message.")
+	fs.StringVar(&rawurl, "u", "", "Endpoint URL.")
+	fs.StringVar(&rawurl, "url", "", "Endpoint URL.")
+	fs.StringVar(&format, "f", "", "Response format.")
+	fs.StringVar(&format, "format", "", "Response format.")
+	fs.StringVar(&root, "r", "", "Path to the data within the response.")
+	fs.StringVar(&root, "root", "", "Path to the data within the response.")
+
+	if err := fs.Parse(args); err != nil {
+		printAndDie(fmt.Sprintf("error parsing arguments: %s", err.Error()), false)
+	}
+	if showHelp {
+		inferUsage()
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "missing url or file\n%s\n", inferUsageStr)
+		os.Exit(1)
+	}
+
+	src := fs.Arg(0)
+	var body []byte
+	var contentType string
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		body, contentType, err = fetchSample(src)
+		if rawurl == "" {
+			rawurl = src
+		}
+	} else {
+		body, err = ioutil.ReadFile(src)
+	}
+	if err != nil {
+		printAndDie(fmt.Sprintf("error loading sample response: %s", err), false)
+	}
+	if rawurl == "" {
+		printAndDie("missing endpoint url; use the --url option when inferring from a file", false)
+	}
+
+	cep, err := service.InferEndpoint(rawurl, body, format, contentType, root)
+	if err != nil {
+		printAndDie(err.Error(), false)
+	}
+
+	out, err := json.MarshalIndent(cep, "", "\t")
+	if err != nil {
+		printAndDie(fmt.Sprintf("error encoding config: %s", err), false)
+	}
+	fmt.Printf("%s\n", out)
+}
+
+// fetchSample fetches a sample response, returning the body and content
+// type.
+func fetchSample(rawurl string) ([]byte, string, error) {
+	c := &http.Client{Timeout: inferTimeout}
+	resp, err := c.Get(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.Header.Get("Content-Type"), err
+}
@@ -18,6 +18,8 @@ var stopTimeout = 10 * time.Second
 
 var usageStr = `
 Usage: rest2res [options]
+       rest2res infer [options] <url|file>
+       rest2res validate [options]
 
 Service Options:
     -n, --nats <url>                 NATS Server URL (default: nats://127.0.0.1:4222)
@@ -29,9 +31,10 @@ Common Options:
 
 // Config holds server configuration
 type Config struct {
-	NatsURL        string `json:"natsUrl"`
-	ExternalAccess bool   `json:"externalAccess"`
-	Debug          bool   `json:"debug,omitempty"`
+	NatsURL        string           `json:"natsUrl"`
+	Nats           *service.NatsCfg `json:"nats,omitempty"`
+	ExternalAccess bool             `json:"externalAccess"`
+	Debug          bool             `json:"debug,omitempty"`
 	service.Config
 }
 
@@ -122,6 +125,17 @@ func printAndDie(msg string, showUsage bool) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "infer":
+			infer(os.Args[2:])
+			return
+		case "validate":
+			validate(os.Args[2:])
+			return
+		}
+	}
+
 	fs := flag.NewFlagSet("rest2res", flag.ExitOnError)
 	fs.Usage = usage
 
@@ -136,13 +150,18 @@ func main() {
 		printAndDie(err.Error(), false)
 	}
 
+	opts, err := cfg.Nats.Options()
+	if err != nil {
+		printAndDie(fmt.Sprintf("invalid nats config: %s", err), false)
+	}
+
 	s.SetLogger(logger.NewStdLogger(cfg.Debug, cfg.Debug))
 
 	// Start service in separate goroutine
 	stop := make(chan bool)
 	go func() {
 		defer close(stop)
-		if err := s.ListenAndServe("nats://localhost:4222"); err != nil {
+		if err := s.ListenAndServe(cfg.NatsURL, opts...); err != nil {
 			fmt.Printf("%s\n", err.Error())
 		}
 	}()
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// An authenticator adds credentials to outgoing HTTP requests.
+type authenticator interface {
+	authenticate(req *http.Request) error
+}
+
+// An invalidator is an authenticator whose credentials may be renewed
+// after being rejected by the server.
+type invalidator interface {
+	invalidate(req *http.Request)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+type bearerAuth struct {
+	token string
+}
+
+type apiKeyAuth struct {
+	name    string
+	key     string
+	inQuery bool
+}
+
+func (a *basicAuth) authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *bearerAuth) authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *apiKeyAuth) authenticate(req *http.Request) error {
+	if a.inQuery {
+		q := req.URL.Query()
+		q.Set(a.name, a.key)
+		req.URL.RawQuery = q.Encode()
+	} else {
+		req.Header.Set(a.name, a.key)
+	}
+	return nil
+}
+
+// newAuthenticator creates an authenticator from the auth config.
+// Returns nil if no config is provided.
+func newAuthenticator(s *Service, c *AuthCfg) (authenticator, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	switch c.Type {
+	case "basic":
+		username, err := expandSecrets(c.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := expandSecrets(c.Password)
+		if err != nil {
+			return nil, err
+		}
+		return &basicAuth{username: username, password: password}, nil
+	case "bearer":
+		token, err := expandSecrets(c.Token)
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return nil, errors.New("missing bearer token")
+		}
+		return &bearerAuth{token: token}, nil
+	case "apiKey":
+		if c.Name == "" {
+			return nil, errors.New("missing api key name")
+		}
+		key, err := expandSecrets(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		var inQuery bool
+		switch c.In {
+		case "", "header":
+		case "query":
+			inQuery = true
+		default:
+			return nil, fmt.Errorf("invalid api key location: %s", c.In)
+		}
+		return &apiKeyAuth{name: c.Name, key: key, inQuery: inQuery}, nil
+	case "oauth2":
+		if c.TokenURL == "" {
+			return nil, errors.New("missing token url")
+		}
+		clientID, err := expandSecrets(c.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		clientSecret, err := expandSecrets(c.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		return &oauth2Auth{t: s.oauth2Token(c.TokenURL, clientID, clientSecret, c.Scopes)}, nil
+	}
+	return nil, fmt.Errorf("invalid auth type: %s", c.Type)
+}
+
+// expandSecrets replaces any ${env:NAME} tag with the value of the
+// environment variable NAME, and any ${file:PATH} tag with the content
+// of the file at PATH, trimmed of trailing line breaks.
+func expandSecrets(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j == -1 {
+			return "", fmt.Errorf("unexpected end of tag in %q", s)
+		}
+		b.WriteString(s[:i])
+		tag := s[i+2 : i+j]
+		s = s[i+j+1:]
+
+		switch {
+		case strings.HasPrefix(tag, "env:"):
+			name := tag[4:]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s not set", name)
+			}
+			b.WriteString(v)
+		case strings.HasPrefix(tag, "file:"):
+			data, err := ioutil.ReadFile(tag[5:])
+			if err != nil {
+				return "", fmt.Errorf("error reading secret file: %s", err)
+			}
+			b.WriteString(strings.TrimRight(string(data), "\r\n"))
+		default:
+			return "", fmt.Errorf("invalid tag ${%s}", tag)
+		}
+	}
+}
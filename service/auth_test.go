@@ -0,0 +1,44 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rest2res")
+	AssertNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "secret")
+	AssertNoError(t, ioutil.WriteFile(file, []byte("filesecret\n"), 0600))
+	AssertNoError(t, os.Setenv("REST2RES_TEST_SECRET", "envsecret"))
+	defer os.Unsetenv("REST2RES_TEST_SECRET")
+
+	tbl := []struct {
+		In       string
+		Expected string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"${env:REST2RES_TEST_SECRET}", "envsecret"},
+		{"Token ${file:" + file + "}", "Token filesecret"},
+		{"${env:REST2RES_TEST_SECRET}:${file:" + file + "}", "envsecret:filesecret"},
+	}
+
+	for _, l := range tbl {
+		s, err := expandSecrets(l.In)
+		AssertNoError(t, err)
+		if s != l.Expected {
+			t.Errorf("expected %q to expand to %q, but got %q", l.In, l.Expected, s)
+		}
+	}
+
+	for _, in := range []string{"${env:REST2RES_TEST_MISSING}", "${file:" + filepath.Join(dir, "missing") + "}", "${foo}", "${env:"} {
+		if _, err := expandSecrets(in); err == nil {
+			t.Errorf("expected %q to fail", in)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+
+	res "github.com/jirenius/go-res"
+)
+
+// setChanges sets the change detection settings of the node from the
+// resource config. Ignored properties are given by the names returned by
+// the legacy endpoint, and are stored by their model property names.
+func (n *node) setChanges(r ResourceCfg) error {
+	if len(r.IgnoreChanges) == 0 && r.FloatTolerance == 0 {
+		return nil
+	}
+	if n.typ != resourceTypeModel {
+		return errors.New("ignoreChanges and floatTolerance must only be used on model resources")
+	}
+	if r.FloatTolerance < 0 {
+		return errors.New("floatTolerance must not be negative")
+	}
+	if len(r.IgnoreChanges) > 0 {
+		n.ignore = make(map[string]bool, len(r.IgnoreChanges))
+		for _, k := range r.IgnoreChanges {
+			if name, ok := n.proj.name(k); ok {
+				n.ignore[name] = true
+			}
+		}
+	}
+	n.tolerance = r.FloatTolerance
+	return nil
+}
+
+// ignores reports whether changes to the model property should not by
+// themselves trigger a change event.
+func (n *node) ignores(k string) bool {
+	return n != nil && n.ignore[k]
+}
+
+// withinTolerance reports whether a and b are both numbers differing by no
+// more than the float tolerance of the node.
+func (n *node) withinTolerance(a, b interface{}) bool {
+	if n == nil || n.tolerance == 0 {
+		return false
+	}
+	av, ok := a.(value)
+	if !ok || av.typ != valueTypeNumber {
+		return false
+	}
+	bv, ok := b.(value)
+	if !ok || bv.typ != valueTypeNumber {
+		return false
+	}
+	af, err := strconv.ParseFloat(string(av.raw), 64)
+	if err != nil {
+		return false
+	}
+	bf, err := strconv.ParseFloat(string(bv.raw), 64)
+	if err != nil {
+		return false
+	}
+	return math.Abs(af-bf) <= n.tolerance
+}
+
+// modelEvents receives the events of a model diff. It is implemented by
+// res.Resource.
+type modelEvents interface {
+	ChangeEvent(props map[string]interface{})
+}
+
+// updateModel sends a change event for the changes between model a and b.
+// Numbers within the float tolerance of the node are kept unchanged in b,
+// so that small changes never add up unnoticed. Changes to ignored
+// properties are only sent along with other changes. If no event is sent,
+// the old values of the ignored properties are kept in b, so that they are
+// included in the next event sent. Returns true if b was modified.
+func (n *node) updateModel(a, b map[string]interface{}, r modelEvents) bool {
+	ch := make(map[string]interface{})
+	send := false
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			ch[k] = res.DeleteAction
+			send = send || !n.ignores(k)
+		}
+	}
+
+	modified := false
+	for k, v := range b {
+		ov, ok := a[k]
+		// Data values hold decoded JSON, and are compared structurally
+		if ok && reflect.DeepEqual(v, ov) {
+			continue
+		}
+		if ok && n.withinTolerance(ov, v) {
+			b[k] = ov
+			modified = true
+			continue
+		}
+		ch[k] = v
+		send = send || !n.ignores(k)
+	}
+
+	if send {
+		r.ChangeEvent(ch)
+		return modified
+	}
+
+	// Only ignored properties changed
+	for k := range ch {
+		if ov, ok := a[k]; ok {
+			b[k] = ov
+		} else {
+			delete(b, k)
+		}
+		modified = true
+	}
+	return modified
+}
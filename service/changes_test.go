@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	res "github.com/jirenius/go-res"
+)
+
+// changeRecorder records the change events of a model diff.
+type changeRecorder struct {
+	events []map[string]interface{}
+}
+
+func (cr *changeRecorder) ChangeEvent(props map[string]interface{}) {
+	cr.events = append(cr.events, props)
+}
+
+func testModel(t *testing.T, s string) map[string]interface{} {
+	var v value
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]interface{}, len(v.obj))
+	for k, pv := range v.obj {
+		m[k] = pv
+	}
+	return m
+}
+
+func TestSetChanges(t *testing.T) {
+	n := &node{typ: resourceTypeModel}
+	n.proj, _ = newProjection(ResourceCfg{Type: "model", Rename: map[string]string{"ts": "updated"}})
+	AssertNoError(t, n.setChanges(ResourceCfg{IgnoreChanges: []string{"ts", "etag"}, FloatTolerance: 0.5}))
+	if !n.ignores("updated") || !n.ignores("etag") || n.ignores("ts") {
+		t.Errorf("expected ignored properties to be mapped by projection, but got %v", n.ignore)
+	}
+	if n.tolerance != 0.5 {
+		t.Errorf("expected tolerance 0.5, but got %v", n.tolerance)
+	}
+}
+
+func TestSetChangesErrors(t *testing.T) {
+	tbl := []struct {
+		Type string
+		Cfg  ResourceCfg
+	}{
+		{"collection", ResourceCfg{IgnoreChanges: []string{"ts"}}},
+		{"collection", ResourceCfg{FloatTolerance: 0.1}},
+		{"model", ResourceCfg{FloatTolerance: -1}},
+	}
+
+	for i, l := range tbl {
+		n := &node{typ: resourceTypeModel}
+		if l.Type == "collection" {
+			n.typ = resourceTypeCollection
+		}
+		if err := n.setChanges(l.Cfg); err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+}
+
+func TestUpdateModel(t *testing.T) {
+	tbl := []struct {
+		A        string
+		B        string
+		Expected string // Expected change event, or empty if none
+		Modified bool
+	}{
+		{`{"name":"foo","ts":1}`, `{"name":"foo","ts":1}`, ``, false},
+		{`{"name":"foo","ts":1}`, `{"name":"bar","ts":1}`, `{"name":"bar"}`, false},
+		// Ignored properties
+		{`{"name":"foo","ts":1}`, `{"name":"foo","ts":2}`, ``, true},
+		{`{"name":"foo","ts":1}`, `{"name":"foo"}`, ``, true},
+		{`{"name":"foo","ts":1}`, `{"name":"bar","ts":2}`, `{"name":"bar","ts":2}`, false},
+		{`{"name":"foo","ts":1}`, `{"name":"foo","ts":2,"extra":true}`, `{"ts":2,"extra":true}`, false},
+		// Float tolerance
+		{`{"temp":20.5}`, `{"temp":20.6}`, ``, true},
+		{`{"temp":20.5}`, `{"temp":21.5}`, `{"temp":21.5}`, false},
+		{`{"temp":20.5}`, `{"temp":"20.5"}`, `{"temp":"20.5"}`, false},
+		{`{"temp":20.5,"name":"foo"}`, `{"temp":20.7,"name":"bar"}`, `{"name":"bar"}`, true},
+	}
+
+	n := &node{typ: resourceTypeModel, ignore: map[string]bool{"ts": true}, tolerance: 0.25}
+	for i, l := range tbl {
+		a := testModel(t, l.A)
+		b := testModel(t, l.B)
+		cr := &changeRecorder{}
+		modified := n.updateModel(a, b, cr)
+		if modified != l.Modified {
+			t.Errorf("test %d: expected modified to be %v, but got %v", i, l.Modified, modified)
+		}
+		if l.Expected == "" {
+			if len(cr.events) != 0 {
+				t.Errorf("test %d: expected no change event, but got %v", i, cr.events)
+			}
+			continue
+		}
+		if len(cr.events) != 1 {
+			t.Errorf("test %d: expected 1 change event, but got %d", i, len(cr.events))
+			continue
+		}
+		expected := testModel(t, l.Expected)
+		if !reflect.DeepEqual(cr.events[0], expected) {
+			t.Errorf("test %d: expected change event %v, but got %v", i, expected, cr.events[0])
+		}
+	}
+}
+
+func TestUpdateModelDeletedProperty(t *testing.T) {
+	n := &node{typ: resourceTypeModel}
+	cr := &changeRecorder{}
+	n.updateModel(testModel(t, `{"a":1,"b":2}`), testModel(t, `{"a":1}`), cr)
+	if len(cr.events) != 1 || cr.events[0]["b"] != res.DeleteAction {
+		t.Errorf("expected delete action for b, but got %v", cr.events)
+	}
+}
+
+func TestUpdateModelKeepsValuesWithinTolerance(t *testing.T) {
+	n := &node{typ: resourceTypeModel, tolerance: 0.25}
+	a := testModel(t, `{"temp":20.5}`)
+	// Small changes in the same direction must not drift unnoticed
+	for i, s := range []string{`{"temp":20.7}`, `{"temp":20.8}`} {
+		b := testModel(t, s)
+		cr := &changeRecorder{}
+		n.updateModel(a, b, cr)
+		if i == 0 && len(cr.events) != 0 {
+			t.Fatalf("expected no change event, but got %v", cr.events)
+		}
+		if i == 1 && len(cr.events) != 1 {
+			t.Fatalf("expected a change event, but got %v", cr.events)
+		}
+		a = b
+	}
+}
+
+func TestUpdateModelIgnoredOverPolls(t *testing.T) {
+	n := &node{typ: resourceTypeModel, ignore: map[string]bool{"ts": true, "gen": true}}
+	polls := []struct {
+		Body  string
+		Event bool
+	}{
+		{`{"name":"foo","ts":2}`, false},
+		{`{"name":"foo","ts":3,"gen":1}`, false},
+		{`{"name":"bar","ts":3,"gen":1}`, true},
+		{`{"name":"bar"}`, false},
+		{`{"name":"baz"}`, true},
+	}
+
+	// Subscribers start with the initial model, and apply each event
+	cache := testModel(t, `{"name":"foo","ts":1}`)
+	client := testModel(t, `{"name":"foo","ts":1}`)
+	for i, l := range polls {
+		b := testModel(t, l.Body)
+		cr := &changeRecorder{}
+		n.updateModel(cache, b, cr)
+		cache = b
+		for _, ev := range cr.events {
+			for k, v := range ev {
+				if v == res.DeleteAction {
+					delete(client, k)
+				} else {
+					client[k] = v
+				}
+			}
+		}
+		if l.Event != (len(cr.events) == 1) {
+			t.Errorf("poll %d: expected event to be %v, but got %v", i, l.Event, cr.events)
+		}
+		// The cached model must always match what subscribers have
+		if !reflect.DeepEqual(cache, client) {
+			t.Errorf("poll %d: expected cache %v to match subscribers %v", i, cache, client)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	res "github.com/jirenius/go-res"
+)
+
+type diffMode byte
+
+const (
+	diffLCS diffMode = iota
+	diffKey
+)
+
+// collectionEvents receives the events of a collection diff. It is
+// implemented by res.Resource.
+type collectionEvents interface {
+	AddEvent(v interface{}, idx int)
+	RemoveEvent(idx int)
+}
+
+// setDiff sets the collection diff settings of the node from the resource
+// config.
+func (n *node) setDiff(r ResourceCfg) error {
+	switch r.Diff {
+	case "", "lcs":
+		if r.Key != "" || r.ReorderReset != 0 {
+			return errors.New("key and reorderReset must only be used with key diff")
+		}
+		return nil
+	case "key":
+	default:
+		return fmt.Errorf("invalid diff: %s", r.Diff)
+	}
+	if n.typ != resourceTypeCollection {
+		return errors.New("diff must only be used on collection resources")
+	}
+	if n.param != nil && n.param.typ == resourceTypeModel && n.param.ptyp != pathTypeProperty {
+		return errors.New("key diff requires idProp to be set on the models of the collection")
+	}
+	if r.ReorderReset < 0 {
+		return errors.New("reorderReset must not be negative")
+	}
+	n.diff = diffKey
+	n.key = r.Key
+	n.reorderReset = r.ReorderReset
+	return nil
+}
+
+// elementKey returns the key of a collection element. References are keyed
+// by resource ID, and primitive values by their JSON value. Data values are
+// keyed by the key property of the node, if set and found, or else by
+// their JSON value.
+func (n *node) elementKey(e interface{}) (string, error) {
+	switch v := e.(type) {
+	case res.Ref:
+		return "r" + string(v), nil
+	case dataValue:
+		if obj, ok := v.data.(map[string]interface{}); ok && n.key != "" {
+			if kv, ok := obj[n.key]; ok {
+				b, err := json.Marshal(kv)
+				return "k" + string(b), err
+			}
+		}
+		b, err := json.Marshal(v.data)
+		return "d" + string(b), err
+	}
+	b, err := json.Marshal(e)
+	return "v" + string(b), err
+}
+
+// updateCollectionByKey sends events for the changes between collection a
+// and b, matching elements by their key. Moved elements are removed and
+// added back, using the fewest moves possible. No events are sent if ok is
+// false, in case the elements are not uniquely keyed, or if reset is true,
+// in case the number of moves exceeds the node's reorder reset threshold.
+func (n *node) updateCollectionByKey(a, b []interface{}, r collectionEvents) (ok bool, reset bool) {
+	akeys, ok := n.uniqueKeys(a)
+	if !ok {
+		return false, false
+	}
+	bkeys, ok := n.uniqueKeys(b)
+	if !ok {
+		return false, false
+	}
+	bidx := make(map[string]int, len(b))
+	for j, k := range bkeys {
+		bidx[k] = j
+	}
+
+	// Elements of a still in b, by index in a, and their index in b
+	var kept []int
+	var pos []int
+	var removes []int
+	for i, k := range akeys {
+		if j, ok := bidx[k]; ok {
+			kept = append(kept, i)
+			pos = append(pos, j)
+		} else {
+			removes = append(removes, i)
+		}
+	}
+
+	// Elements in the longest increasing subsequence of positions stay,
+	// while the others are moved. Elements with changed content are
+	// replaced.
+	stay := make([]bool, len(b))
+	moves := 0
+	lis := longestIncreasing(pos)
+	for li, l := 0, 0; li < len(kept); li++ {
+		if l < len(lis) && lis[l] == li {
+			l++
+			if reflect.DeepEqual(a[kept[li]], b[pos[li]]) {
+				stay[pos[li]] = true
+			} else {
+				removes = append(removes, kept[li])
+			}
+			continue
+		}
+		moves++
+		removes = append(removes, kept[li])
+	}
+	if n.reorderReset > 0 && moves > n.reorderReset {
+		return true, true
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(removes)))
+	for _, i := range removes {
+		r.RemoveEvent(i)
+	}
+	for j, v := range b {
+		if !stay[j] {
+			r.AddEvent(v, j)
+		}
+	}
+	return true, false
+}
+
+// uniqueKeys returns the element keys of the collection. The bool is false
+// if any key cannot be created, or if the keys are not unique.
+func (n *node) uniqueKeys(c []interface{}) ([]string, bool) {
+	keys := make([]string, len(c))
+	seen := make(map[string]bool, len(c))
+	for i, e := range c {
+		k, err := n.elementKey(e)
+		if err != nil || seen[k] {
+			return nil, false
+		}
+		seen[k] = true
+		keys[i] = k
+	}
+	return keys, true
+}
+
+// longestIncreasing returns the indexes of a longest strictly increasing
+// subsequence of s, in increasing order.
+func longestIncreasing(s []int) []int {
+	// tails[l] is the index in s of the smallest tail of all increasing
+	// subsequences of length l+1.
+	tails := make([]int, 0, len(s))
+	prev := make([]int, len(s))
+	for i, v := range s {
+		l := sort.Search(len(tails), func(t int) bool { return s[tails[t]] >= v })
+		if l > 0 {
+			prev[i] = tails[l-1]
+		} else {
+			prev[i] = -1
+		}
+		if l == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[l] = i
+		}
+	}
+
+	lis := make([]int, len(tails))
+	if len(tails) == 0 {
+		return lis
+	}
+	for i, l := len(tails)-1, tails[len(tails)-1]; i >= 0; i-- {
+		lis[i] = l
+		l = prev[l]
+	}
+	return lis
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	res "github.com/jirenius/go-res"
+)
+
+// eventRecorder applies collection events to a collection, and counts
+// them.
+type eventRecorder struct {
+	c       []interface{}
+	adds    int
+	removes int
+}
+
+func (er *eventRecorder) AddEvent(v interface{}, idx int) {
+	er.c = append(er.c, nil)
+	copy(er.c[idx+1:], er.c[idx:])
+	er.c[idx] = v
+	er.adds++
+}
+
+func (er *eventRecorder) RemoveEvent(idx int) {
+	er.c = append(er.c[:idx], er.c[idx+1:]...)
+	er.removes++
+}
+
+func refs(ids ...string) []interface{} {
+	c := make([]interface{}, len(ids))
+	for i, id := range ids {
+		c[i] = res.Ref("s.items." + id)
+	}
+	return c
+}
+
+func TestUpdateCollectionByKey(t *testing.T) {
+	tbl := []struct {
+		A       []interface{}
+		B       []interface{}
+		Adds    int
+		Removes int
+	}{
+		{refs("a", "b", "c"), refs("a", "b", "c"), 0, 0},
+		{refs("a", "b", "c"), refs("a", "c"), 0, 1},
+		{refs("a", "b", "c"), refs("a", "b", "x", "c"), 1, 0},
+		{refs("a", "b", "c", "d"), refs("b", "c", "d", "a"), 1, 1},
+		{refs("a", "b", "c", "d"), refs("d", "a", "b", "c"), 1, 1},
+		{refs("a", "b", "c", "d"), refs("d", "c", "b", "a"), 3, 3},
+		{refs("a", "b", "c", "d"), refs("x", "c", "a", "y"), 3, 3},
+		{refs(), refs("a", "b"), 2, 0},
+		{refs("a", "b"), refs(), 0, 2},
+	}
+
+	n := &node{typ: resourceTypeCollection, diff: diffKey}
+	for i, l := range tbl {
+		er := &eventRecorder{c: append([]interface{}(nil), l.A...)}
+		ok, reset := n.updateCollectionByKey(l.A, l.B, er)
+		if !ok || reset {
+			t.Errorf("test %d: expected ok and no reset, but got %v, %v", i, ok, reset)
+			continue
+		}
+		if len(er.c) != len(l.B) || (len(l.B) > 0 && !reflect.DeepEqual(er.c, l.B)) {
+			t.Errorf("test %d: expected %v, but got %v", i, l.B, er.c)
+		}
+		if er.adds != l.Adds || er.removes != l.Removes {
+			t.Errorf("test %d: expected %d adds and %d removes, but got %d and %d", i, l.Adds, l.Removes, er.adds, er.removes)
+		}
+	}
+}
+
+func TestUpdateCollectionByKeyWithDataValues(t *testing.T) {
+	data := func(s string) interface{} {
+		var v value
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		dv, err := newDataValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dv
+	}
+	a := []interface{}{data(`{"id":1,"n":"a"}`), data(`{"id":2,"n":"b"}`)}
+	b := []interface{}{data(`{"id":2,"n":"b"}`), data(`{"id":1,"n":"x"}`)}
+
+	n := &node{typ: resourceTypeCollection, diff: diffKey, key: "id"}
+	er := &eventRecorder{c: append([]interface{}(nil), a...)}
+	ok, _ := n.updateCollectionByKey(a, b, er)
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if !reflect.DeepEqual(er.c, b) {
+		t.Errorf("expected %v, but got %v", b, er.c)
+	}
+	if er.adds != 1 || er.removes != 1 {
+		t.Errorf("expected 1 add and 1 remove, but got %d and %d", er.adds, er.removes)
+	}
+}
+
+func TestUpdateCollectionByKeyFallback(t *testing.T) {
+	n := &node{typ: resourceTypeCollection, diff: diffKey}
+	er := &eventRecorder{}
+	ok, _ := n.updateCollectionByKey(refs("a", "a"), refs("a"), er)
+	if ok || er.adds+er.removes > 0 {
+		t.Errorf("expected no events for duplicate keys")
+	}
+}
+
+func TestUpdateCollectionByKeyReorderReset(t *testing.T) {
+	n := &node{typ: resourceTypeCollection, diff: diffKey, reorderReset: 2}
+	er := &eventRecorder{}
+	ok, reset := n.updateCollectionByKey(refs("a", "b", "c", "d"), refs("d", "c", "b", "a"), er)
+	if !ok || !reset || er.adds+er.removes > 0 {
+		t.Errorf("expected reset without events, but got %v, %v with %d events", ok, reset, er.adds+er.removes)
+	}
+	er = &eventRecorder{c: refs("a", "b", "c", "d")}
+	ok, reset = n.updateCollectionByKey(refs("a", "b", "c", "d"), refs("b", "a", "c", "d"), er)
+	if !ok || reset {
+		t.Errorf("expected no reset, but got %v, %v", ok, reset)
+	}
+}
+
+func TestUpdateCollectionByKeyRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	n := &node{typ: resourceTypeCollection, diff: diffKey}
+	for i := 0; i < 200; i++ {
+		a := refs(randomIDs(rnd, 20)...)
+		b := refs(randomIDs(rnd, 20)...)
+		er := &eventRecorder{c: append([]interface{}(nil), a...)}
+		if ok, _ := n.updateCollectionByKey(a, b, er); !ok {
+			t.Fatalf("test %d: expected ok", i)
+		}
+		if len(er.c) != len(b) || (len(b) > 0 && !reflect.DeepEqual(er.c, b)) {
+			t.Fatalf("test %d: expected %v, but got %v", i, b, er.c)
+		}
+	}
+}
+
+// randomIDs returns a random number of unique IDs, in random order, out
+// of max IDs.
+func randomIDs(rnd *rand.Rand, max int) []string {
+	var ids []string
+	for _, i := range rnd.Perm(max)[:rnd.Intn(max)] {
+		ids = append(ids, fmt.Sprint(i))
+	}
+	return ids
+}
+
+func TestLongestIncreasing(t *testing.T) {
+	tbl := []struct {
+		S        []int
+		Expected int
+	}{
+		{nil, 0},
+		{[]int{1}, 1},
+		{[]int{0, 1, 2, 3}, 4},
+		{[]int{3, 2, 1, 0}, 1},
+		{[]int{3, 0, 1, 2}, 3},
+		{[]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9}, 4},
+	}
+
+	for i, l := range tbl {
+		lis := longestIncreasing(l.S)
+		if len(lis) != l.Expected {
+			t.Errorf("test %d: expected length %d, but got %d", i, l.Expected, len(lis))
+		}
+		for j := 1; j < len(lis); j++ {
+			if lis[j] <= lis[j-1] || l.S[lis[j]] <= l.S[lis[j-1]] {
+				t.Errorf("test %d: subsequence %v is not increasing", i, lis)
+			}
+		}
+	}
+}
@@ -4,25 +4,97 @@ import res "github.com/jirenius/go-res"
 
 // Config holds server configuration
 type Config struct {
-	ServiceName string        `json:"serviceName"`
-	Endpoints   []EndpointCfg `json:"endpoints"`
+	ServiceName    string                  `json:"serviceName"`
+	CircuitBreaker *CircuitBreakerCfg      `json:"circuitBreaker,omitempty"`
+	RateLimits     map[string]RateLimitCfg `json:"rateLimits,omitempty"`
+	Unmapped       string                  `json:"unmapped,omitempty"`
+	Endpoints      []EndpointCfg           `json:"endpoints"`
+}
+
+// CircuitBreakerCfg holds the circuit breaker settings applied per
+// upstream host.
+type CircuitBreakerCfg struct {
+	FailureThreshold int `json:"failureThreshold"`
+	OpenTime         int `json:"openTime"`
+}
+
+// RateLimitCfg holds the settings for a named rate limit pool.
+type RateLimitCfg struct {
+	Requests int  `json:"requests"`
+	Interval int  `json:"interval"`
+	Burst    int  `json:"burst,omitempty"`
+	PerHost  bool `json:"perHost,omitempty"`
+}
+
+// PaginationCfg holds the settings for following the pages of a
+// paginated endpoint.
+type PaginationCfg struct {
+	Type      string `json:"type"`
+	MaxPages  int    `json:"maxPages"`
+	ItemsPath string `json:"itemsPath,omitempty"`
+	NextPath  string `json:"nextPath,omitempty"`
+	Param     string `json:"param,omitempty"`
+	Start     int    `json:"start,omitempty"`
 }
 
 type EndpointCfg struct {
-	URL          string `json:"url"`
-	RefreshTime  int    `json:"refreshTime"`
-	RefreshCount int    `json:"refreshCount"`
-	Timeout      int    `json:"timeout"`
-	Access       res.AccessHandler
+	URL               string            `json:"url"`
+	Format            string            `json:"format,omitempty"`
+	RefreshTime       int               `json:"refreshTime"`
+	RefreshCount      int               `json:"refreshCount"`
+	Timeout           int               `json:"timeout"`
+	FetchTimeout      int               `json:"fetchTimeout,omitempty"`
+	HonorCacheHeaders bool              `json:"honorCacheHeaders,omitempty"`
+	MinRefreshTime    int               `json:"minRefreshTime,omitempty"`
+	MaxRefreshTime    int               `json:"maxRefreshTime,omitempty"`
+	Retries           int               `json:"retries,omitempty"`
+	RetryDelay        int               `json:"retryDelay,omitempty"`
+	MaxRetryDelay     int               `json:"maxRetryDelay,omitempty"`
+	StaleIfError      int               `json:"staleIfError,omitempty"`
+	StaleProp         string            `json:"staleProp,omitempty"`
+	StatusMap         map[string]string `json:"statusMap,omitempty"`
+	ErrorMessageProp  string            `json:"errorMessageProp,omitempty"`
+	RateLimit         string            `json:"rateLimit,omitempty"`
+	Pagination        *PaginationCfg    `json:"pagination,omitempty"`
+	Root              string            `json:"root,omitempty"`
+	EnvelopePattern   string            `json:"envelopePattern,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Auth              *AuthCfg          `json:"auth,omitempty"`
+	Access            res.AccessHandler `json:"-"`
 	ResourceCfg
 }
 
+// AuthCfg holds the authentication scheme used when fetching an endpoint.
+// String values may contain ${env:NAME} and ${file:PATH} tags.
+type AuthCfg struct {
+	Type         string   `json:"type"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	Token        string   `json:"token,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Key          string   `json:"key,omitempty"`
+	In           string   `json:"in,omitempty"`
+	TokenURL     string   `json:"tokenUrl,omitempty"`
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
 type ResourceCfg struct {
-	Type      string        `json:"type,omitempty"`
-	Pattern   string        `json:"pattern,omitempty"`
-	Path      string        `json:"path,omitempty"`
-	IDProp    string        `json:"idProp,omitempty"`
-	Resources []ResourceCfg `json:"resources,omitempty"`
+	Type           string            `json:"type,omitempty"`
+	Pattern        string            `json:"pattern,omitempty"`
+	Path           string            `json:"path,omitempty"`
+	IDProp         string            `json:"idProp,omitempty"`
+	Include        []string          `json:"include,omitempty"`
+	Exclude        []string          `json:"exclude,omitempty"`
+	Rename         map[string]string `json:"rename,omitempty"`
+	Unmapped       string            `json:"unmapped,omitempty"`
+	Diff           string            `json:"diff,omitempty"`
+	Key            string            `json:"key,omitempty"`
+	ReorderReset   int               `json:"reorderReset,omitempty"`
+	IgnoreChanges  []string          `json:"ignoreChanges,omitempty"`
+	FloatTolerance float64           `json:"floatTolerance,omitempty"`
+	Resources      []ResourceCfg     `json:"resources,omitempty"`
 }
 
 // SetDefault sets the default values
@@ -33,6 +105,14 @@ func (c *Config) SetDefault() {
 	if c.Endpoints == nil {
 		c.Endpoints = []EndpointCfg{}
 	}
+	if cb := c.CircuitBreaker; cb != nil {
+		if cb.FailureThreshold == 0 {
+			cb.FailureThreshold = 5
+		}
+		if cb.OpenTime == 0 {
+			cb.OpenTime = 30000
+		}
+	}
 	for i := range c.Endpoints {
 		ep := &c.Endpoints[i]
 		if ep.RefreshTime == 0 {
@@ -41,5 +121,23 @@ func (c *Config) SetDefault() {
 		if ep.RefreshCount == 0 {
 			ep.RefreshCount = 12
 		}
+		if ep.MinRefreshTime == 0 {
+			ep.MinRefreshTime = 1000
+		}
+		if ep.MaxRefreshTime == 0 {
+			ep.MaxRefreshTime = 300000
+		}
+		if ep.FetchTimeout == 0 {
+			ep.FetchTimeout = 30000
+		}
+		if pg := ep.Pagination; pg != nil && pg.MaxPages == 0 {
+			pg.MaxPages = 10
+		}
+		if ep.RetryDelay == 0 {
+			ep.RetryDelay = 200
+		}
+		if ep.MaxRetryDelay == 0 {
+			ep.MaxRetryDelay = 5000
+		}
 	}
 }
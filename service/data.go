@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+type unmappedMode byte
+
+const (
+	unmappedOmit unmappedMode = iota
+	unmappedData
+	unmappedError
+)
+
+func parseUnmappedMode(s string) (unmappedMode, error) {
+	switch s {
+	case "", "omit":
+		return unmappedOmit, nil
+	case "data":
+		return unmappedData, nil
+	case "error":
+		return unmappedError, nil
+	}
+	return unmappedOmit, fmt.Errorf("invalid unmapped setting: %s", s)
+}
+
+// A dataValue is a RES data value, containing a JSON object or array that
+// is not mapped to a resource. The data is held as decoded JSON, using
+// json.Number for numbers, so that values may be compared structurally
+// with reflect.DeepEqual.
+type dataValue struct {
+	data interface{}
+}
+
+// newDataValue creates a data value containing the value.
+func newDataValue(v value) (dataValue, error) {
+	data, err := v.decode()
+	return dataValue{data: data}, err
+}
+
+// MarshalJSON encodes the data value as a RES data value object.
+func (dv dataValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Data interface{} `json:"data"`
+	}{dv.data})
+}
+
+// unmappedValue returns the model property or collection element to use for an
+// object or array value with no node to map it to, as set by the node's
+// unmapped mode. The bool is false if the value should be omitted.
+func (n *node) unmappedValue(v value, desc string, path []string) (interface{}, bool, error) {
+	switch n.unmapped {
+	case unmappedData:
+		dv, err := newDataValue(v)
+		if err != nil {
+			return nil, false, err
+		}
+		return dv, true, nil
+	case unmappedError:
+		return nil, false, fmt.Errorf("unmapped nested value for %s at %s", desc, pathStr(path))
+	}
+	return nil, false, nil
+}
+
+// decode returns the value decoded into maps, slices, and primitive values,
+// with numbers decoded as json.Number.
+func (v value) decode() (interface{}, error) {
+	switch v.typ {
+	case valueTypeObject:
+		m := make(map[string]interface{}, len(v.obj))
+		for k, kv := range v.obj {
+			d, err := kv.decode()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = d
+		}
+		return m, nil
+	case valueTypeArray:
+		a := make([]interface{}, len(v.arr))
+		for i, iv := range v.arr {
+			d, err := iv.decode()
+			if err != nil {
+				return nil, err
+			}
+			a[i] = d
+		}
+		return a, nil
+	case valueTypeTrue:
+		return true, nil
+	case valueTypeFalse:
+		return false, nil
+	case valueTypeNull:
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(v.raw))
+	dec.UseNumber()
+	var d interface{}
+	err := dec.Decode(&d)
+	return d, err
+}
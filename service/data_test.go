@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func traverseTestModel(t *testing.T, mode unmappedMode, data string) (map[string]cachedResource, error) {
+	root := &node{}
+	AssertNoError(t, root.addPath("", "station", nil, "model", ""))
+	root.unmapped = mode
+
+	var v value
+	AssertNoError(t, json.Unmarshal([]byte(data), &v))
+	crs := make(map[string]cachedResource)
+	_, err := traverseModel(crs, v, nil, root, nil, "")
+	return crs, err
+}
+
+func TestTraverseModelUnmappedOmit(t *testing.T) {
+	crs, err := traverseTestModel(t, unmappedOmit, `{"id":1,"pos":{"lat":1.5,"lng":2},"tags":["a"]}`)
+	AssertNoError(t, err)
+	model := crs["station"].model
+	if len(model) != 1 {
+		t.Errorf("expected 1 property, but got %d", len(model))
+	}
+}
+
+func TestTraverseCollectionUnmappedOmit(t *testing.T) {
+	root := &node{}
+	AssertNoError(t, root.addPath("", "list", nil, "collection", ""))
+	root.unmapped = unmappedOmit
+
+	var v value
+	AssertNoError(t, json.Unmarshal([]byte(`["a",{"b":1},[2],"c"]`), &v))
+	crs := make(map[string]cachedResource)
+	_, err := traverseCollection(crs, v, nil, root, nil, "")
+	AssertNoError(t, err)
+	b, err := json.Marshal(crs["list"].collection)
+	AssertNoError(t, err)
+	if string(b) != `["a",null,null,"c"]` {
+		t.Errorf("expected omitted elements to be null, but got %s", b)
+	}
+}
+
+func TestTraverseModelUnmappedData(t *testing.T) {
+	crs, err := traverseTestModel(t, unmappedData, `{"id":1,"pos":{"lat":1.5,"lng":2},"tags":["a",null]}`)
+	AssertNoError(t, err)
+	model := crs["station"].model
+	for k, expected := range map[string]string{
+		"pos":  `{"data":{"lat":1.5,"lng":2}}`,
+		"tags": `{"data":["a",null]}`,
+	} {
+		b, err := json.Marshal(model[k])
+		AssertNoError(t, err)
+		if string(b) != expected {
+			t.Errorf("expected property %s to be %s, but got %s", k, expected, b)
+		}
+	}
+}
+
+func TestTraverseModelUnmappedError(t *testing.T) {
+	if _, err := traverseTestModel(t, unmappedError, `{"id":1,"pos":{"lat":1.5}}`); err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+	if _, err := traverseTestModel(t, unmappedError, `{"id":1}`); err != nil {
+		t.Errorf("expected no error, but got: %s", err)
+	}
+}
+
+func TestDataValueStructuralEquality(t *testing.T) {
+	tbl := []struct {
+		A     string
+		B     string
+		Equal bool
+	}{
+		{`{"a":1,"b":[true,"x"]}`, `{ "b": [ true, "x" ], "a": 1 }`, true},
+		{`{"a":"A"}`, `{"a":"A"}`, true},
+		{`{"a":1}`, `{"a":2}`, false},
+		{`[1,2]`, `[2,1]`, false},
+		{`{"a":null}`, `{}`, false},
+	}
+
+	for i, l := range tbl {
+		var a, b value
+		AssertNoError(t, json.Unmarshal([]byte(l.A), &a))
+		AssertNoError(t, json.Unmarshal([]byte(l.B), &b))
+		da, err := newDataValue(a)
+		AssertNoError(t, err)
+		db, err := newDataValue(b)
+		AssertNoError(t, err)
+		if reflect.DeepEqual(da, db) != l.Equal {
+			t.Errorf("test %d: expected equality to be %v", i, l.Equal)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	res "github.com/jirenius/go-res"
+)
+
+// updateCollection sends events for the changes between collection a and
+// b, using a shortest edit script found with Myers' diff algorithm in
+// linear space. Elements are interned into integer IDs, so that each
+// element is encoded only once, and compared by ID when diffing.
+//
+// All remove events are sent first, in descending index order, followed by
+// the add events in ascending index order.
+func updateCollection(a, b []interface{}, r collectionEvents) {
+	// Trim of matches at the start and end
+	s := 0
+	m := len(a)
+	n := len(b)
+	for s < m && s < n && reflect.DeepEqual(a[s], b[s]) {
+		s++
+	}
+	if s == m && s == n {
+		return
+	}
+	for s < m && s < n && reflect.DeepEqual(a[m-1], b[n-1]) {
+		m--
+		n--
+	}
+
+	d := newDiffer(a[s:m], b[s:n])
+	d.compare(0, len(d.a), 0, len(d.b))
+
+	for i := len(d.a) - 1; i >= 0; i-- {
+		if d.removed[i] {
+			r.RemoveEvent(s + i)
+		}
+	}
+	for j, added := range d.added {
+		if added {
+			r.AddEvent(b[s+j], s+j)
+		}
+	}
+}
+
+// A differ holds the state for finding a shortest edit script between two
+// sequences of interned elements.
+type differ struct {
+	a, b    []int
+	removed []bool
+	added   []bool
+	vf, vb  []int // Furthest reaching x per diagonal, forward and backward
+}
+
+func newDiffer(a, b []interface{}) *differ {
+	ids := make(map[string]int, len(a)+len(b))
+	unique := -1
+	intern := func(c []interface{}) []int {
+		s := make([]int, len(c))
+		for i, e := range c {
+			k, ok := elementID(e)
+			if !ok {
+				// Never equal to any other element
+				s[i] = unique
+				unique--
+				continue
+			}
+			id, ok := ids[k]
+			if !ok {
+				id = len(ids)
+				ids[k] = id
+			}
+			s[i] = id
+		}
+		return s
+	}
+	size := 4*(len(a)+len(b)) + 5
+	return &differ{
+		a:       intern(a),
+		b:       intern(b),
+		removed: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+		vf:      make([]int, size),
+		vb:      make([]int, size),
+	}
+}
+
+// elementID returns a string identifying the value of a collection
+// element, such that two elements have the same ID only if they are deeply
+// equal. The bool is false if no ID could be created.
+func elementID(e interface{}) (string, bool) {
+	switch v := e.(type) {
+	case res.Ref:
+		return "r" + string(v), true
+	case value:
+		return "v" + string(rune('0'+v.typ)) + string(v.raw), true
+	case dataValue:
+		b, err := json.Marshal(v.data)
+		return "d" + string(b), err == nil
+	}
+	b, err := json.Marshal(e)
+	return "j" + string(b), err == nil
+}
+
+// compare marks the removed and added elements between a[aLo:aHi] and
+// b[bLo:bHi], by recursively splitting the sequences at the middle snake of
+// an optimal edit path.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi {
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+		return
+	}
+	if bLo == bHi {
+		for i := aLo; i < aHi; i++ {
+			d.removed[i] = true
+		}
+		return
+	}
+
+	x, y := d.middleSnake(aLo, aHi, bLo, bHi)
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+// middleSnake returns a point on an optimal edit path between a[aLo:aHi]
+// and b[bLo:bHi], splitting it into two paths with about half of the
+// edits each. The sequences must not be empty, and must not share a
+// common first or last element.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int) {
+	n := aHi - aLo
+	m := bHi - bLo
+	delta := n - m
+	odd := delta&1 != 0
+	// Diagonal k is x - y, relative to (aLo, bLo), and is stored at k+off
+	off := 2*(n+m) + 2
+	vf := d.vf[:4*(n+m)+5]
+	vb := d.vb[:4*(n+m)+5]
+	vf[off+1] = 0
+	vb[off+delta-1] = n
+
+	for dd := 0; ; dd++ {
+		// Forward path
+		for k := -dd; k <= dd; k += 2 {
+			var x int
+			if k == -dd || (k != dd && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if odd && k >= delta-(dd-1) && k <= delta+(dd-1) && x >= vb[off+k] {
+				return aLo + x, bLo + y
+			}
+		}
+
+		// Backward path
+		for k := -dd + delta; k <= dd+delta; k += 2 {
+			var x int
+			if k == dd+delta || (k != -dd+delta && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k-1]
+			} else {
+				x = vb[off+k+1] - 1
+			}
+			y := x - k
+			for x > 0 && y > 0 && d.a[aLo+x-1] == d.b[bLo+y-1] {
+				x--
+				y--
+			}
+			vb[off+k] = x
+			if !odd && k >= -dd && k <= dd && x <= vf[off+k] {
+				return aLo + x, bLo + y
+			}
+		}
+	}
+}
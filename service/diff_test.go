@@ -0,0 +1,237 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	res "github.com/jirenius/go-res"
+)
+
+// eventLog records collection events.
+type eventLog []string
+
+func (el *eventLog) AddEvent(v interface{}, idx int) {
+	*el = append(*el, fmt.Sprintf("add %v %d", v, idx))
+}
+
+func (el *eventLog) RemoveEvent(idx int) {
+	*el = append(*el, fmt.Sprintf("remove %d", idx))
+}
+
+// uniqueCollection returns a collection of n unique references.
+func uniqueCollection(n int) []interface{} {
+	c := make([]interface{}, n)
+	for i := range c {
+		c[i] = res.Ref(fmt.Sprintf("s.items.%d", i))
+	}
+	return c
+}
+
+// scatter returns a copy of the collection with count scattered changes,
+// each being either an insert, a remove, or a replacement of an element.
+func scatter(rnd *rand.Rand, c []interface{}, count int) []interface{} {
+	nc := append([]interface{}(nil), c...)
+	for i := 0; i < count; i++ {
+		idx := rnd.Intn(len(nc))
+		switch rnd.Intn(3) {
+		case 0:
+			nc = append(nc[:idx], append([]interface{}{res.Ref(fmt.Sprintf("s.new.%d", i))}, nc[idx:]...)...)
+		case 1:
+			nc = append(nc[:idx], nc[idx+1:]...)
+		default:
+			nc[idx] = res.Ref(fmt.Sprintf("s.replaced.%d", i))
+		}
+	}
+	return nc
+}
+
+func TestUpdateCollectionMatchesLCS(t *testing.T) {
+	tbl := []struct {
+		A []interface{}
+		B []interface{}
+	}{
+		{refs("a", "b", "c"), refs("a", "b", "c")},
+		{refs(), refs("a")},
+		{refs("a"), refs()},
+		{refs("a", "b", "c"), refs("a", "b", "c", "d")},
+		{refs("a", "b", "c"), refs("x", "a", "b", "c")},
+		{refs("a", "b", "c"), refs("a", "x", "b", "c")},
+		{refs("a", "b", "c"), refs("a", "c")},
+		{refs("a", "b", "c"), refs("a", "x", "c")},
+		{refs("a", "b", "c", "d", "e"), refs("x", "b", "y", "d", "z")},
+		{refs("a", "b", "c", "d", "e"), refs("b", "d")},
+		{refs("a", "b"), refs("x", "y", "z")},
+	}
+
+	for i, l := range tbl {
+		var expected, got eventLog
+		lcsUpdateCollection(l.A, l.B, &expected)
+		updateCollection(l.A, l.B, &got)
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("test %d: expected events %v, but got %v", i, expected, got)
+		}
+	}
+}
+
+func TestUpdateCollectionMatchesLCSOnScatteredChanges(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	a := uniqueCollection(200)
+	for i := 0; i < 200; i++ {
+		b := scatter(rnd, a, 1+rnd.Intn(10))
+		var expected, got eventLog
+		lcsUpdateCollection(a, b, &expected)
+		updateCollection(a, b, &got)
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("test %d: expected events %v, but got %v", i, expected, got)
+		}
+	}
+}
+
+func TestUpdateCollectionIsMinimal(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		// Small alphabet to get many equal elements
+		a := make([]interface{}, rnd.Intn(30))
+		b := make([]interface{}, rnd.Intn(30))
+		for j := range a {
+			a[j] = res.Ref(fmt.Sprint(rnd.Intn(4)))
+		}
+		for j := range b {
+			b[j] = res.Ref(fmt.Sprint(rnd.Intn(4)))
+		}
+
+		var expected eventLog
+		lcsUpdateCollection(a, b, &expected)
+		er := &eventRecorder{c: append([]interface{}(nil), a...)}
+		updateCollection(a, b, er)
+		if len(er.c) != len(b) || (len(b) > 0 && !reflect.DeepEqual(er.c, b)) {
+			t.Fatalf("test %d: expected %v, but got %v", i, b, er.c)
+		}
+		if er.adds+er.removes != len(expected) {
+			t.Fatalf("test %d: expected %d events, but got %d", i, len(expected), er.adds+er.removes)
+		}
+	}
+}
+
+func benchmarkUpdateCollection(b *testing.B, n, changes int, update func(a, b []interface{}, r collectionEvents)) {
+	rnd := rand.New(rand.NewSource(1))
+	ca := uniqueCollection(n)
+	cb := scatter(rnd, ca, changes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var el eventLog
+		update(ca, cb, &el)
+	}
+}
+
+func BenchmarkUpdateCollection_1k_10(b *testing.B) {
+	benchmarkUpdateCollection(b, 1000, 10, updateCollection)
+}
+
+func BenchmarkUpdateCollectionLCS_1k_10(b *testing.B) {
+	benchmarkUpdateCollection(b, 1000, 10, lcsUpdateCollection)
+}
+
+func BenchmarkUpdateCollection_20k_100(b *testing.B) {
+	benchmarkUpdateCollection(b, 20000, 100, updateCollection)
+}
+
+// lcsUpdateCollection is the previous LCS matrix implementation of
+// updateCollection, used as reference.
+func lcsUpdateCollection(a, b []interface{}, r collectionEvents) {
+	var i, j int
+	// Do a LCS matric calculation
+	// https://en.wikipedia.org/wiki/Longest_common_subsequence_problem
+	s := 0
+	m := len(a)
+	n := len(b)
+
+	// Trim of matches at the start and end
+	for s < m && s < n && reflect.DeepEqual(a[s], b[s]) {
+		s++
+	}
+
+	if s == m && s == n {
+		return
+	}
+
+	for s < m && s < n && reflect.DeepEqual(a[m-1], b[n-1]) {
+		m--
+		n--
+	}
+
+	var aa, bb []interface{}
+	if s > 0 || m < len(a) {
+		aa = a[s:m]
+		m = m - s
+	} else {
+		aa = a
+	}
+	if s > 0 || n < len(b) {
+		bb = b[s:n]
+		n = n - s
+	} else {
+		bb = b
+	}
+
+	// Create matrix and initialize it
+	w := m + 1
+	c := make([]int, w*(n+1))
+
+	for i = 0; i < m; i++ {
+		for j = 0; j < n; j++ {
+			if reflect.DeepEqual(aa[i], bb[j]) {
+				c[(i+1)+w*(j+1)] = c[i+w*j] + 1
+			} else {
+				v1 := c[(i+1)+w*j]
+				v2 := c[i+w*(j+1)]
+				if v2 > v1 {
+					c[(i+1)+w*(j+1)] = v2
+				} else {
+					c[(i+1)+w*(j+1)] = v1
+				}
+			}
+		}
+	}
+
+	idx := m + s
+	i = m
+	j = n
+	rm := 0
+
+	var adds [][3]int
+	addCount := n - c[w*(n+1)-1]
+	if addCount > 0 {
+		adds = make([][3]int, 0, addCount)
+	}
+Loop:
+	for {
+		m = i - 1
+		n = j - 1
+		switch {
+		case i > 0 && j > 0 && reflect.DeepEqual(aa[m], bb[n]):
+			idx--
+			i--
+			j--
+		case j > 0 && (i == 0 || c[i+w*n] >= c[m+w*j]):
+			adds = append(adds, [3]int{n, idx, rm})
+			j--
+		case i > 0 && (j == 0 || c[i+w*n] < c[m+w*j]):
+			idx--
+			r.RemoveEvent(idx)
+			rm++
+			i--
+		default:
+			break Loop
+		}
+	}
+
+	// Do the adds
+	l := len(adds) - 1
+	for i := l; i >= 0; i-- {
+		add := adds[i]
+		r.AddEvent(bb[add[0]], add[1]-rm+add[2]+l-i)
+	}
+}
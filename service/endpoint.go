@@ -1,12 +1,14 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,37 +19,78 @@ import (
 )
 
 type endpoint struct {
-	s             *Service
-	url           string
-	urlParams     []string
-	refreshCount  int
-	cachedURLs    map[string]*cachedResponse
-	access        res.AccessHandler
-	timeout       time.Duration
-	group         string
-	resetPatterns []string
-	tq            *timerqueue.Queue
-	mu            sync.RWMutex
+	s                *Service
+	url              string
+	urlParams        []string
+	format           format
+	refreshCount     int
+	refreshTime      time.Duration
+	cacheHeaders     bool
+	minRefresh       time.Duration
+	maxRefresh       time.Duration
+	retries          int
+	retryDelay       time.Duration
+	maxRetryDelay    time.Duration
+	staleIfError     time.Duration
+	staleProp        string
+	staleURLs        map[string]*cachedResponse
+	cachedURLs       map[string]*cachedResponse
+	inflight         map[string]*fetchCall
+	headers          http.Header
+	auth             authenticator
+	access           res.AccessHandler
+	timeout          time.Duration
+	fetchTimeout     time.Duration
+	statusMap        statusMap
+	errorMessageProp string
+	rateLimit        *rateLimitPool
+	pagination       *pagination
+	root             string
+	envelope         *node
+	resetPatterns    []string
+	tq               *timerqueue.Queue
+	mu               sync.RWMutex
 	node
 }
 
 type cachedResponse struct {
-	reloads   int
-	reqParams map[string]string
-	crs       map[string]cachedResource
-	rerr      *res.Error
+	mu           sync.RWMutex
+	reloads      int
+	reqParams    map[string]string
+	crs          map[string]cachedResource
+	root         string
+	rerr         *res.Error
+	fetched      time.Time
+	staleSince   time.Time
+	etag         string
+	lastModified string
+	notModified  bool
+	refreshIn    time.Duration
+	reset        bool // Removed from the cache, and its resources reset
+}
+
+// fetchCall is an in-flight fetch of a url that is to be cached.
+type fetchCall struct {
+	done  chan struct{}
+	cresp *cachedResponse
 }
 
 type cachedResource struct {
 	typ        resourceType
 	model      map[string]interface{}
 	collection []interface{}
+	n          *node        // Node of the resource
+	hash       resourceHash // Content hash of the model or collection
 }
 
 type resourceType byte
 
 const defaultRefreshDuration = time.Second * 3
 
+// fetchTimeoutMargin is the time added to the fetch timeout when extending
+// the timeout of client requests.
+const fetchTimeoutMargin = time.Second
+
 const (
 	resourceTypeUnset resourceType = iota
 	resourceTypeModel
@@ -67,16 +110,87 @@ func newEndpoint(s *Service, cep *EndpointCfg) (*endpoint, error) {
 		return nil, err
 	}
 
-	ep := &endpoint{
-		s:            s,
-		url:          cep.URL,
-		urlParams:    urlParams,
-		refreshCount: cep.RefreshCount,
-		cachedURLs:   make(map[string]*cachedResponse),
-		access:       cep.Access,
-		timeout:      time.Millisecond * time.Duration(cep.Timeout),
+	headers := make(http.Header, len(cep.Headers))
+	for k, v := range cep.Headers {
+		v, err = expandSecrets(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %s: %s", k, err)
+		}
+		headers.Set(k, v)
+	}
+
+	f, err := parseFormat(cep.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthenticator(s, cep.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth: %s", err)
+	}
+
+	var rl *rateLimitPool
+	if cep.RateLimit != "" {
+		rl = s.rateLimits[cep.RateLimit]
+		if rl == nil {
+			return nil, fmt.Errorf("rate limit %s not found", cep.RateLimit)
+		}
 	}
-	ep.tq = timerqueue.New(ep.handleRefresh, time.Millisecond*time.Duration(cep.RefreshTime))
+
+	pg, err := newPagination(cep.Pagination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pagination: %s", err)
+	}
+	if pg != nil && cep.Type != "collection" {
+		return nil, errors.New("pagination must only be used on collection endpoints")
+	}
+	// The root selects the items of each page, unless an items path is set
+	if pg != nil && pg.itemsPath == "" {
+		pg.itemsPath = cep.Root
+	}
+
+	sm, err := newStatusMap(cep.StatusMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statusMap: %s", err)
+	}
+
+	if cep.StaleProp != "" && cep.Type != "model" {
+		return nil, errors.New("staleProp must only be used on model endpoints")
+	}
+	if cep.HonorCacheHeaders && cep.MaxRefreshTime > 0 && cep.MinRefreshTime > cep.MaxRefreshTime {
+		return nil, errors.New("minRefreshTime must not be greater than maxRefreshTime")
+	}
+
+	ep := &endpoint{
+		s:                s,
+		url:              cep.URL,
+		urlParams:        urlParams,
+		format:           f,
+		refreshCount:     cep.RefreshCount,
+		refreshTime:      time.Millisecond * time.Duration(cep.RefreshTime),
+		cacheHeaders:     cep.HonorCacheHeaders,
+		minRefresh:       time.Millisecond * time.Duration(cep.MinRefreshTime),
+		maxRefresh:       time.Millisecond * time.Duration(cep.MaxRefreshTime),
+		retries:          cep.Retries,
+		retryDelay:       time.Millisecond * time.Duration(cep.RetryDelay),
+		maxRetryDelay:    time.Millisecond * time.Duration(cep.MaxRetryDelay),
+		staleIfError:     time.Millisecond * time.Duration(cep.StaleIfError),
+		staleProp:        cep.StaleProp,
+		staleURLs:        make(map[string]*cachedResponse),
+		cachedURLs:       make(map[string]*cachedResponse),
+		inflight:         make(map[string]*fetchCall),
+		headers:          headers,
+		auth:             auth,
+		access:           cep.Access,
+		timeout:          time.Millisecond * time.Duration(cep.Timeout),
+		fetchTimeout:     time.Millisecond * time.Duration(cep.FetchTimeout),
+		statusMap:        sm,
+		errorMessageProp: cep.ErrorMessageProp,
+		rateLimit:        rl,
+		pagination:       pg,
+		root:             cep.Root,
+	}
+	ep.tq = timerqueue.New(ep.handleRefresh, ep.refreshTime)
 
 	return ep, nil
 }
@@ -85,14 +199,26 @@ func (ep *endpoint) handler() res.Handler {
 	return res.Handler{
 		Access:      ep.access,
 		GetResource: ep.getResource,
-		Group:       ep.url,
 	}
 }
 
+// handleRefresh is called by the timer queue when a url is due to be
+// refreshed. The refresh is made on a separate goroutine, to not block the
+// refresh of other urls.
 func (ep *endpoint) handleRefresh(i interface{}) {
-	ep.s.Debugf("Refreshing %s", i)
+	go ep.refresh(i.(string))
+}
 
-	url := i.(string)
+// refresh fetches the url and sends events for any changes to the cached
+// resources. The url is fetched without holding any lock, while the cached
+// response lock is held when applying the changes, to ensure events are
+// sent in order with get responses for the same url.
+func (ep *endpoint) refresh(url string) {
+	// No polling is done once the service is shut down
+	if ep.s.stopped() {
+		return
+	}
+	ep.s.Debugf("Refreshing %s", url)
 
 	// Check if url is cached
 	ep.mu.RLock()
@@ -105,178 +231,191 @@ func (ep *endpoint) handleRefresh(i interface{}) {
 
 	params := cresp.reqParams
 
-	ep.s.res.WithGroup(url, func(s *res.Service) {
-		cresp.reloads++
-		if cresp.rerr != nil || cresp.reloads > ep.refreshCount {
-			// Reset resources
-			ep.mu.Lock()
-			delete(ep.cachedURLs, url)
-			ep.mu.Unlock()
-
-			resetResources := make([]string, len(ep.resetPatterns))
-			for i, rp := range ep.resetPatterns {
-				for _, param := range ep.urlParams {
-					rp = strings.Replace(rp, "${"+param+"}", params[param], 1)
-				}
-				resetResources[i] = rp
+	cresp.reloads++
+	// Stale responses are kept until refreshed or expired, but never beyond
+	// the staleIfError window.
+	if cresp.rerr != nil || (cresp.reloads > ep.refreshCount && !ep.isStale(cresp)) || ep.staleExpired(cresp) {
+		// Reset resources. The response lock is held until the reset is
+		// sent, so that no get response for the url is sent after it.
+		cresp.mu.Lock()
+		defer cresp.mu.Unlock()
+		cresp.reset = true
+		ep.mu.Lock()
+		delete(ep.cachedURLs, url)
+		ep.keepStale(url, cresp)
+		ep.mu.Unlock()
+
+		resetResources := make([]string, len(ep.resetPatterns))
+		for i, rp := range ep.resetPatterns {
+			for _, param := range ep.urlParams {
+				rp = strings.Replace(rp, "${"+param+"}", params[param], 1)
 			}
-			ep.s.res.Reset(resetResources, nil)
-			return
+			resetResources[i] = rp
 		}
+		ep.s.res.Reset(resetResources, nil)
+		return
+	}
 
-		defer ep.tq.Add(i)
+	ncresp := ep.getURL(url, params, cresp)
+	if ep.s.stopped() {
+		return
+	}
+
+	ep.s.res.WithGroup(url, func(s *res.Service) {
+		cresp.mu.Lock()
+		defer cresp.mu.Unlock()
+		defer ep.scheduleRefresh(url, cresp)
 
-		ncresp := ep.getURL(url, params)
+		cresp.refreshIn = ncresp.refreshIn
 		if ncresp.rerr != nil {
 			ep.s.Logf("Error refreshing url %s:\n\t%s", url, ncresp.rerr.Message)
+			if ep.staleIfError > 0 {
+				ep.markStale(cresp, true)
+			}
 			return
 		}
+		if ncresp.notModified {
+			ep.s.Tracef("Url %s not modified", url)
+			cresp.fetched = time.Now()
+			ep.clearStale(cresp)
+			return
+		}
+		cresp.staleSince = time.Time{}
 
+		// Update existing resources. Resources not previously cached
+		// are added by swapping in the new cachedResources, and will be
+		// fetched by Resgate when referenced in the events.
 		for rid, nv := range ncresp.crs {
 			v, ok := cresp.crs[rid]
 			if ok {
-				r, err := ep.s.res.Resource(rid)
-				if err != nil {
-					// This shouldn't be possible. Let's panic.
-					panic(fmt.Sprintf("error getting res resource %s:\n\t%s", rid, err))
-				}
-
-				updateResource(v, nv, r)
-				delete(cresp.crs, rid)
+				ncresp.crs[rid] = ep.updateResource(rid, v, nv)
+			} else {
+				ep.s.Tracef("Resource %s added", rid)
 			}
 		}
 
-		// for rid := range cresp.crs {
-		// 	r, err := ep.s.res.Resource(rid)
-		// 	r.DeleteEvent()
-		// }
+		// Delete resources no longer produced by the endpoint. This is done
+		// after the update events, so that any references are first removed.
+		for _, rid := range vanishedResources(cresp.crs, ncresp.crs) {
+			ep.s.Debugf("Resource %s deleted", rid)
+			ep.resource(rid).DeleteEvent()
+		}
 
 		// Replacing the old cachedResources with the new ones
 		cresp.crs = ncresp.crs
+		cresp.root = ncresp.root
+		cresp.fetched = ncresp.fetched
+		cresp.etag = ncresp.etag
+		cresp.lastModified = ncresp.lastModified
 	})
 }
 
-func updateResource(v, nv cachedResource, r res.Resource) {
-	switch v.typ {
-	case resourceTypeModel:
-		updateModel(v.model, nv.model, r)
-	case resourceTypeCollection:
-		updateCollection(v.collection, nv.collection, r)
-	}
-}
-
-func updateModel(a, b map[string]interface{}, r res.Resource) {
-	ch := make(map[string]interface{})
-	for k := range a {
-		if _, ok := b[k]; !ok {
-			ch[k] = res.DeleteAction
-		}
-	}
-
-	for k, v := range b {
-		ov, ok := a[k]
-		if !(ok && reflect.DeepEqual(v, ov)) {
-			ch[k] = v
+// vanishedResources returns the sorted IDs of the resources in crs that
+// are not in ncrs.
+func vanishedResources(crs, ncrs map[string]cachedResource) []string {
+	var rids []string
+	for rid := range crs {
+		if _, ok := ncrs[rid]; !ok {
+			rids = append(rids, rid)
 		}
 	}
-
-	r.ChangeEvent(ch)
+	sort.Strings(rids)
+	return rids
 }
 
-func updateCollection(a, b []interface{}, r res.Resource) {
-	var i, j int
-	// Do a LCS matric calculation
-	// https://en.wikipedia.org/wiki/Longest_common_subsequence_problem
-	s := 0
-	m := len(a)
-	n := len(b)
-
-	// Trim of matches at the start and end
-	for s < m && s < n && reflect.DeepEqual(a[s], b[s]) {
-		s++
+// resource returns the res resource for the resource ID.
+func (ep *endpoint) resource(rid string) res.Resource {
+	r, err := ep.s.res.Resource(rid)
+	if err != nil {
+		// This shouldn't be possible. Let's panic.
+		panic(fmt.Sprintf("error getting res resource %s:\n\t%s", rid, err))
 	}
+	return r
+}
 
-	if s == m && s == n {
+// scheduleRefresh queues the cached url to be refreshed. If the endpoint
+// honors cache headers, the refresh is scheduled using the delay of the
+// cached response instead of the fixed refresh time. If the upstream host is
+// backing off due to rate limiting, the refresh is delayed until the back
+// off ends.
+func (ep *endpoint) scheduleRefresh(url string, cresp *cachedResponse) {
+	d := ep.refreshTime
+	if ep.cacheHeaders {
+		d = cresp.refreshIn
+	}
+	backoff := ep.s.upstreamFor(url).backoffRemaining()
+	if !ep.cacheHeaders && backoff <= d {
+		ep.tq.Add(url)
 		return
 	}
-
-	for s < m && s < n && reflect.DeepEqual(a[m-1], b[n-1]) {
-		m--
-		n--
+	if backoff > d {
+		d = backoff
 	}
+	time.AfterFunc(d, func() {
+		if ep.s.stopped() {
+			return
+		}
+		// Make sure the url has not been reset and cached again
+		// since the refresh was scheduled.
+		ep.mu.RLock()
+		cur := ep.cachedURLs[url]
+		ep.mu.RUnlock()
+		if cur == cresp {
+			ep.handleRefresh(url)
+		}
+	})
+}
 
-	var aa, bb []interface{}
-	if s > 0 || m < len(a) {
-		aa = a[s:m]
-		m = m - s
-	} else {
-		aa = a
+// refreshDelay returns the duration until a response with the given headers
+// should be refreshed, clamped between the endpoint's min and max refresh
+// time. If the headers contain no caching information, the refresh time
+// is used.
+func (ep *endpoint) refreshDelay(h http.Header) time.Duration {
+	now := time.Now()
+	d, ok := freshness(h, now)
+	if !ok {
+		d = ep.refreshTime
 	}
-	if s > 0 || n < len(b) {
-		bb = b[s:n]
-		n = n - s
-	} else {
-		bb = b
+	if ra, ok := retryAfter(h, now); ok && ra > d {
+		d = ra
 	}
-
-	// Create matrix and initialize it
-	w := m + 1
-	c := make([]int, w*(n+1))
-
-	for i = 0; i < m; i++ {
-		for j = 0; j < n; j++ {
-			if reflect.DeepEqual(aa[i], bb[j]) {
-				c[(i+1)+w*(j+1)] = c[i+w*j] + 1
-			} else {
-				v1 := c[(i+1)+w*j]
-				v2 := c[i+w*(j+1)]
-				if v2 > v1 {
-					c[(i+1)+w*(j+1)] = v2
-				} else {
-					c[(i+1)+w*(j+1)] = v1
-				}
-			}
-		}
+	if d < ep.minRefresh {
+		d = ep.minRefresh
 	}
+	if ep.maxRefresh > 0 && d > ep.maxRefresh {
+		d = ep.maxRefresh
+	}
+	return d
+}
 
-	idx := m + s
-	i = m
-	j = n
-	rm := 0
-
-	var adds [][3]int
-	addCount := n - c[w*(n+1)-1]
-	if addCount > 0 {
-		adds = make([][3]int, 0, addCount)
+// updateResource sends events for the changes between the old and new
+// cached resource, and returns the new cached resource to store. No diff is
+// made if the content hashes are the same. Collections using key diff are
+// reset instead if reordered beyond the reorder reset threshold.
+func (ep *endpoint) updateResource(rid string, v, nv cachedResource) cachedResource {
+	if v.sameContent(nv) {
+		return nv
 	}
-Loop:
-	for {
-		m = i - 1
-		n = j - 1
-		switch {
-		case i > 0 && j > 0 && reflect.DeepEqual(aa[m], bb[n]):
-			idx--
-			i--
-			j--
-		case j > 0 && (i == 0 || c[i+w*n] >= c[m+w*j]):
-			adds = append(adds, [3]int{n, idx, rm})
-			j--
-		case i > 0 && (j == 0 || c[i+w*n] < c[m+w*j]):
-			idx--
-			r.RemoveEvent(idx)
-			rm++
-			i--
-		default:
-			break Loop
+	r := ep.resource(rid)
+	switch v.typ {
+	case resourceTypeModel:
+		if nv.n.updateModel(v.model, nv.model, r) {
+			nv.hash = hashModel(nv.model)
 		}
+	case resourceTypeCollection:
+		if nv.n != nil && nv.n.diff == diffKey {
+			ok, reset := nv.n.updateCollectionByKey(v.collection, nv.collection, r)
+			if reset {
+				ep.s.Debugf("Resetting reordered collection %s", rid)
+				ep.s.res.Reset([]string{rid}, nil)
+			}
+			if ok {
+				return nv
+			}
+		}
+		updateCollection(v.collection, nv.collection, r)
 	}
-
-	// Do the adds
-	l := len(adds) - 1
-	for i := l; i >= 0; i-- {
-		add := adds[i]
-		r.AddEvent(bb[add[0]], add[1]-rm+add[2]+l-i)
-	}
+	return nv
 }
 
 func (ep *endpoint) getResource(r res.GetRequest) {
@@ -286,16 +425,31 @@ func (ep *endpoint) getResource(r res.GetRequest) {
 		url = strings.Replace(url, "${"+param+"}", r.PathParam(param), 1)
 	}
 
-	// Check if url is cached
-	ep.mu.RLock()
-	cresp, ok := ep.cachedURLs[url]
-	ep.mu.RUnlock()
-	if !ok {
-		if ep.timeout > 0 {
-			r.Timeout(ep.timeout)
+	var cresp *cachedResponse
+	for {
+		// Check if url is cached
+		ep.mu.RLock()
+		c, ok := ep.cachedURLs[url]
+		ep.mu.RUnlock()
+		if !ok {
+			if d := ep.requestTimeout(); d > 0 {
+				r.Timeout(d)
+			}
+			c = ep.cacheURL(url, r.PathParams())
+		}
+
+		// Hold the lock while responding, to prevent events for the url to
+		// be sent before the response. If the response was reset while
+		// waiting for the lock, the url is looked up again, as no events
+		// will follow.
+		c.mu.RLock()
+		if !c.reset {
+			cresp = c
+			break
 		}
-		cresp = ep.cacheURL(url, r.PathParams())
+		c.mu.RUnlock()
 	}
+	defer cresp.mu.RUnlock()
 
 	// Return any encountered error when getting the endpoint
 	if cresp.rerr != nil {
@@ -318,74 +472,354 @@ func (ep *endpoint) getResource(r res.GetRequest) {
 	}
 }
 
+// cacheURL fetches and caches the url, unless it is already cached.
+// Concurrent calls for the same url share a single fetch, with all callers
+// getting the same cached response.
 func (ep *endpoint) cacheURL(url string, reqParams map[string]string) *cachedResponse {
-	cresp := ep.getURL(url, reqParams)
 	ep.mu.Lock()
+	if cresp, ok := ep.cachedURLs[url]; ok {
+		ep.mu.Unlock()
+		return cresp
+	}
+	if c, ok := ep.inflight[url]; ok {
+		ep.mu.Unlock()
+		<-c.done
+		return c.cresp
+	}
+	c := &fetchCall{done: make(chan struct{})}
+	ep.inflight[url] = c
+	ep.mu.Unlock()
+
+	cresp := ep.getURL(url, reqParams, nil)
+	ep.mu.Lock()
+	if cresp.rerr != nil {
+		if stale := ep.staleFallback(url, cresp.rerr); stale != nil {
+			cresp = stale
+		}
+	} else {
+		delete(ep.staleURLs, url)
+	}
 	ep.cachedURLs[url] = cresp
+	delete(ep.inflight, url)
+	c.cresp = cresp
 	ep.mu.Unlock()
-	ep.tq.Add(url)
+	close(c.done)
+	ep.scheduleRefresh(url, cresp)
 
 	return cresp
 }
 
-func (ep *endpoint) getURL(url string, reqParams map[string]string) *cachedResponse {
-	cr := cachedResponse{reqParams: reqParams}
+// getURL fetches the url and traverses the response data.
+// If prev is not nil, the request is made conditional using the validators of
+// the previous response, and a 304 Not Modified response results in a
+// cachedResponse with notModified set.
+func (ep *endpoint) getURL(url string, reqParams map[string]string, prev *cachedResponse) *cachedResponse {
+	cr := cachedResponse{reqParams: reqParams, refreshIn: ep.refreshTime}
+	retries := ep.retries
+	if prev != nil {
+		// Failed refreshes are retried on next poll
+		retries = 0
+	}
+
+	// The fetch is canceled on shutdown, or when the fetch timeout is reached
+	ctx := ep.s.ctx
+	if ep.fetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ep.fetchTimeout)
+		defer cancel()
+	}
+
 	// Make HTTP request
-	resp, err := http.Get(url)
+	resp, err := ep.fetchRetry(ctx, url, prev, retries)
 	if err != nil {
 		ep.s.Debugf("Error fetching endpoint: %s\n\t%s", url, err)
-		cr.rerr = res.InternalError(err)
+		cr.rerr = fetchError(ctx, err)
 		return &cr
 	}
 	defer resp.Body.Close()
 
-	// Handle non-2XX status codes
-	if resp.StatusCode == 404 {
-		cr.rerr = res.ErrNotFound
+	if ep.cacheHeaders {
+		cr.refreshIn = ep.refreshDelay(resp.Header)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		cr.notModified = true
 		return &cr
 	}
+
+	// Handle non-2XX status codes
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		cr.rerr = res.InternalError(fmt.Errorf("unexpected response code: %d", resp.StatusCode))
+		cr.rerr = ep.statusError(resp)
 		return &cr
 	}
 
-	// Read body
-	body, err := ioutil.ReadAll(resp.Body)
+	v, err := ep.readValue(resp)
 	if err != nil {
-		cr.rerr = res.InternalError(err)
+		cr.rerr = fetchError(ctx, err)
 		return &cr
 	}
-	// Unmarshal body
-	var v value
-	if err = json.Unmarshal(body, &v); err != nil {
-		cr.rerr = res.InternalError(err)
-		return &cr
+
+	crs := make(map[string]cachedResource)
+	if ep.envelope != nil {
+		if err = ep.traverseEnvelope(crs, v, reqParams); err != nil {
+			cr.rerr = res.InternalError(fmt.Errorf("invalid data structure for %s: %s", url, err))
+			return &cr
+		}
+	}
+
+	if ep.pagination != nil {
+		// Follow any pages, concatenating them into a single array
+		v, err = ep.fetchPages(ctx, url, v, resp.Header, prev, retries)
+		if err != nil {
+			ep.s.Debugf("Error fetching pages: %s\n\t%s", url, err)
+			cr.rerr = fetchError(ctx, err)
+			return &cr
+		}
+	} else if ep.root != "" {
+		// Select the endpoint root within the response
+		lookup := v.lookup
+		if ep.node.typ == resourceTypeCollection {
+			lookup = v.lookupList
+		}
+		var ok bool
+		if v, ok = lookup(ep.root); !ok {
+			cr.rerr = res.InternalError(fmt.Errorf("invalid data structure for %s: missing root %s", url, ep.root))
+			return &cr
+		}
 	}
 
 	// Traverse the data
-	crs := make(map[string]cachedResource)
-	err = ep.traverse(crs, v, nil, reqParams)
+	root, err := ep.traverse(crs, v, nil, reqParams)
 	if err != nil {
 		cr.rerr = res.InternalError(fmt.Errorf("invalid data structure for %s: %s", url, err))
 		return &cr
 	}
 
 	cr.crs = crs
+	cr.root = string(root)
+	cr.fetched = time.Now()
+	cr.etag = resp.Header.Get("ETag")
+	cr.lastModified = resp.Header.Get("Last-Modified")
 	return &cr
 }
 
-func (ep *endpoint) traverse(crs map[string]cachedResource, v value, path []string, reqParams map[string]string) error {
-	var err error
+// readValue reads the response body and decodes it using the endpoint's
+// format.
+func (ep *endpoint) readValue(resp *http.Response) (value, error) {
+	// Read body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return value{}, err
+	}
+	// Decode body
+	return ep.format.decode(body, resp.Header.Get("Content-Type"))
+}
+
+// fetchRetry calls fetch, retrying requests that fails with a network error
+// or a 5XX status code, up to retries times. The delay between each attempt
+// is doubled, starting from the endpoint's retry delay, with random jitter.
+func (ep *endpoint) fetchRetry(ctx context.Context, url string, prev *cachedResponse, retries int) (*http.Response, error) {
+	delay := ep.retryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := ep.fetch(ctx, url, prev)
+		if attempt >= retries || !isRetryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		d := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		ep.s.Debugf("Retrying %s in %s", url, d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		delay *= 2
+		if ep.maxRetryDelay > 0 && delay > ep.maxRetryDelay {
+			delay = ep.maxRetryDelay
+		}
+	}
+}
+
+// fetch makes a GET request to the url. If the request is rejected with
+// 401 Unauthorized and the credentials can be renewed, the request is
+// retried once using new credentials.
+// If the circuit breaker of the upstream host is open, an unavailable
+// *res.Error is returned without making any request.
+// Refreshes, where prev is not nil, have lower priority than other fetches
+// when waiting for the endpoint's rate limit.
+func (ep *endpoint) fetch(ctx context.Context, url string, prev *cachedResponse) (*http.Response, error) {
+	req, err := ep.newRequest(ctx, url, prev)
+	if err != nil {
+		return nil, err
+	}
+	u := ep.s.upstream(req.URL.Host)
+	prio := priorityHigh
+	if prev != nil {
+		prio = priorityLow
+	}
+	resp, err := ep.do(u, req, prio)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		if inv, ok := ep.auth.(invalidator); ok {
+			resp.Body.Close()
+			inv.invalidate(req)
+			if req, err = ep.newRequest(ctx, url, prev); err != nil {
+				return nil, err
+			}
+			return ep.do(u, req, prio)
+		}
+	}
+	return resp, nil
+}
+
+// do waits for the endpoint's rate limit, if any, and sends the request
+// unless the upstream's circuit breaker is open, or the upstream is backing
+// off due to rate limiting. The outcome is recorded with the circuit breaker,
+// and any rate limiting response headers are checked.
+func (ep *endpoint) do(u *upstream, req *http.Request, prio priority) (*http.Response, error) {
+	if ep.rateLimit != nil {
+		if err := ep.rateLimit.bucket(req.URL.Host).wait(req.Context(), prio); err != nil {
+			return nil, err
+		}
+	}
+	// The backoff is checked first, as allow may let the request through as
+	// the probe of a half-open circuit breaker.
+	if u.backoffRemaining() > 0 {
+		return nil, u.rateLimitedError()
+	}
+	if rerr := u.allow(); rerr != nil {
+		return nil, rerr
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		u.checkRateLimit(resp)
+	}
+	switch {
+	case err != nil && req.Context().Err() == context.Canceled:
+		// Canceled requests are not failures of the upstream, but must
+		// release the probe of a half-open circuit breaker.
+		u.canceled()
+	case err != nil || resp.StatusCode >= 500:
+		u.failure()
+	default:
+		u.success()
+	}
+	return resp, err
+}
+
+// requestTimeout returns the timeout to set for client requests waiting
+// for the url to be fetched, or 0 to use the default of Resgate. The timeout
+// is extended beyond the fetch timeout, so that the client gets the timeout
+// error of the fetch rather than the one of Resgate.
+func (ep *endpoint) requestTimeout() time.Duration {
+	d := ep.timeout
+	if ep.fetchTimeout > 0 && ep.fetchTimeout+fetchTimeoutMargin > d {
+		d = ep.fetchTimeout + fetchTimeoutMargin
+	}
+	return d
+}
+
+// fetchError converts an error encountered while fetching using the context
+// to a *res.Error. A timeout error is returned if the context deadline was
+// exceeded.
+func fetchError(ctx context.Context, err error) *res.Error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &res.Error{Code: res.CodeTimeout, Message: "Upstream request timeout"}
+	}
+	return toResError(err)
+}
+
+// isRetryable reports whether a failed request may succeed if retried.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		_, ok := err.(*res.Error)
+		return !ok
+	}
+	return resp.StatusCode >= 500
+}
+
+// toResError converts an error to a *res.Error, wrapping it as an internal
+// error unless it already is a *res.Error.
+func toResError(err error) *res.Error {
+	if rerr, ok := err.(*res.Error); ok {
+		return rerr
+	}
+	return res.InternalError(err)
+}
+
+// newRequest creates a GET request for the url, with the endpoint's
+// headers and authentication applied. If prev is not nil, conditional
+// headers are set from its validators.
+func (ep *endpoint) newRequest(ctx context.Context, url string, prev *cachedResponse) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range ep.headers {
+		req.Header[k] = v
+	}
+	// Paginated responses cannot be conditional, as a later page may have
+	// been modified even if the first is not.
+	if prev != nil && prev.rerr == nil && ep.pagination == nil {
+		if prev.etag != "" {
+			req.Header.Set("If-None-Match", prev.etag)
+		}
+		if prev.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
+		}
+	}
+	if ep.auth != nil {
+		if err := ep.auth.authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (ep *endpoint) traverse(crs map[string]cachedResource, v value, path []string, reqParams map[string]string) (res.Ref, error) {
 	switch v.typ {
 	case valueTypeObject:
-		_, err = traverseModel(crs, v, path, &ep.node, reqParams, "")
+		return traverseModel(crs, v, path, &ep.node, reqParams, "")
 	case valueTypeArray:
-		_, err = traverseCollection(crs, v, path, &ep.node, reqParams, "")
-	default:
-		return errors.New("endpoint didn't respond with a json object or array")
+		return traverseCollection(crs, v, path, &ep.node, reqParams, "")
 	}
-	if err != nil {
-		return err
+	return "", errors.New("endpoint didn't respond with a json object or array")
+}
+
+// traverseEnvelope adds the envelope model, containing the primitive
+// properties of the response object.
+func (ep *endpoint) traverseEnvelope(crs map[string]cachedResource, v value, reqParams map[string]string) error {
+	if v.typ != valueTypeObject {
+		return errors.New("envelope is not a json object")
+	}
+
+	model := make(map[string]interface{})
+	for k, kv := range v.obj {
+		if kv.typ != valueTypeObject && kv.typ != valueTypeArray {
+			model[k] = kv
+		}
+	}
+
+	// Create rid
+	n := ep.envelope
+	p := make([]interface{}, len(n.params))
+	for j, pp := range n.params {
+		p[j] = reqParams[pp.name]
+	}
+	rid := fmt.Sprintf(n.pattern, p...)
+
+	crs[rid] = cachedResource{
+		typ:   resourceTypeModel,
+		model: model,
+		n:     n,
+		hash:  hashModel(model),
 	}
 	return nil
 }
@@ -417,17 +851,40 @@ func traverseModel(crs map[string]cachedResource, v value, path []string, n *nod
 		default:
 			return "", fmt.Errorf("invalid id value for property %s at:\n\t%s", n.idProp, pathStr(path))
 		}
-		path = append(path)
+	}
+
+	if err := n.proj.collision(v.obj); err != nil {
+		return "", fmt.Errorf("%s at %s", err, pathStr(path))
 	}
 
 	model := make(map[string]interface{})
 	for k, kv := range v.obj {
+		name, ok := n.proj.name(k)
+		if !ok {
+			continue
+		}
+
 		// Get next node
 		next := n.nodes[k]
 		if next == nil {
 			next = n.param
 		}
 
+		if next != nil && next.typ == resourceTypeCollection {
+			kv, _ = kv.list()
+		}
+
+		if next == nil && (kv.typ == valueTypeObject || kv.typ == valueTypeArray) {
+			dv, ok, err := n.unmappedValue(kv, "property "+k, path)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				model[name] = dv
+			}
+			continue
+		}
+
 		switch kv.typ {
 		case valueTypeObject:
 			if next != nil {
@@ -435,7 +892,7 @@ func traverseModel(crs map[string]cachedResource, v value, path []string, n *nod
 				if err != nil {
 					return "", err
 				}
-				model[k] = ref
+				model[name] = ref
 			}
 		case valueTypeArray:
 			if next != nil {
@@ -443,13 +900,13 @@ func traverseModel(crs map[string]cachedResource, v value, path []string, n *nod
 				if err != nil {
 					return "", err
 				}
-				model[k] = ref
+				model[name] = ref
 			}
 		default:
 			if next != nil {
 				return "", fmt.Errorf("unexpected primitive value for property %s at %s", k, pathStr(path))
 			}
-			model[k] = kv
+			model[name] = kv
 		}
 	}
 
@@ -468,6 +925,8 @@ func traverseModel(crs map[string]cachedResource, v value, path []string, n *nod
 	crs[rid] = cachedResource{
 		typ:   resourceTypeModel,
 		model: model,
+		n:     n,
+		hash:  hashModel(model),
 	}
 	return res.Ref(rid), nil
 }
@@ -482,10 +941,25 @@ func traverseCollection(crs map[string]cachedResource, v value, path []string, n
 		path = append(path, pathPart)
 	}
 
-	collection := make([]interface{}, len(v.arr))
+	collection := make([]interface{}, 0, len(v.arr))
 	for j, kv := range v.arr {
 		next := n.param
 
+		if next != nil && next.typ == resourceTypeCollection {
+			kv, _ = kv.list()
+		}
+
+		if next == nil && (kv.typ == valueTypeObject || kv.typ == valueTypeArray) {
+			dv, _, err := n.unmappedValue(kv, "element "+strconv.Itoa(j), path)
+			if err != nil {
+				return "", err
+			}
+			// Omitted elements are kept as null, so that the indexes of
+			// the other elements are unchanged.
+			collection = append(collection, dv)
+			continue
+		}
+
 		switch kv.typ {
 		case valueTypeObject:
 			if next != nil {
@@ -493,7 +967,7 @@ func traverseCollection(crs map[string]cachedResource, v value, path []string, n
 				if err != nil {
 					return "", err
 				}
-				collection[j] = ref
+				collection = append(collection, ref)
 			}
 		case valueTypeArray:
 			if next != nil {
@@ -501,13 +975,13 @@ func traverseCollection(crs map[string]cachedResource, v value, path []string, n
 				if err != nil {
 					return "", err
 				}
-				collection[j] = ref
+				collection = append(collection, ref)
 			}
 		default:
 			if next != nil {
 				return "", fmt.Errorf("unexpected primitive value for element %d at %s", j, pathStr(path))
 			}
-			collection[j] = kv
+			collection = append(collection, kv)
 		}
 	}
 
@@ -526,6 +1000,8 @@ func traverseCollection(crs map[string]cachedResource, v value, path []string, n
 	crs[rid] = cachedResource{
 		typ:        resourceTypeCollection,
 		collection: collection,
+		n:          n,
+		hash:       hashCollection(collection),
 	}
 	return res.Ref(rid), nil
 }
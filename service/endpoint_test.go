@@ -0,0 +1,118 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService returns a service with a single endpoint fetching the url.
+// Refreshes are scheduled far enough ahead not to run during the test.
+func testService(t *testing.T, url string, r ResourceCfg) (*Service, *endpoint) {
+	cfg := Config{ServiceName: "s", Endpoints: []EndpointCfg{{URL: url, RefreshTime: 3600000, ResourceCfg: r}}}
+	cfg.SetDefault()
+	s, err := NewService(cfg)
+	AssertNoError(t, err)
+	return s, s.endpoints[0]
+}
+
+func TestCacheURLFetchesOnce(t *testing.T) {
+	var hits int32
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-block
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer ts.Close()
+
+	s, ep := testService(t, ts.URL, ResourceCfg{Type: "model", Pattern: "item"})
+	defer s.cancel()
+
+	const callers = 10
+	var wg sync.WaitGroup
+	cresps := make([]*cachedResponse, callers)
+	for i := range cresps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cresps[i] = ep.cacheURL(ts.URL, nil)
+		}(i)
+	}
+	// Let all callers wait for the blocked fetch
+	time.Sleep(50 * time.Millisecond)
+	close(block)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected 1 request, but got %d", n)
+	}
+	for i, cresp := range cresps {
+		if cresp != cresps[0] {
+			t.Errorf("expected caller %d to get the same cached response", i)
+		}
+	}
+	if cresps[0].rerr != nil {
+		t.Fatalf("expected no error, but got %s", cresps[0].rerr.Message)
+	}
+	if _, ok := cresps[0].crs["s.item"]; !ok {
+		t.Errorf("expected resource s.item to be cached")
+	}
+	if ep.cacheURL(ts.URL, nil) != cresps[0] || atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("expected cached response to be reused")
+	}
+}
+
+func TestVanishedResources(t *testing.T) {
+	crs := func(rids ...string) map[string]cachedResource {
+		m := make(map[string]cachedResource, len(rids))
+		for _, rid := range rids {
+			m[rid] = cachedResource{typ: resourceTypeModel}
+		}
+		return m
+	}
+
+	tbl := []struct {
+		Old      map[string]cachedResource
+		New      map[string]cachedResource
+		Expected []string
+	}{
+		{crs("s.a", "s.b"), crs("s.a", "s.b"), nil},
+		{crs("s.a", "s.b"), crs("s.a", "s.b", "s.c"), nil},
+		{crs("s.a", "s.c", "s.b"), crs("s.a"), []string{"s.b", "s.c"}},
+		{crs("s.a", "s.b"), crs("s.a", "s.c"), []string{"s.b"}},
+		{crs("s.a"), crs(), []string{"s.a"}},
+		{crs(), crs("s.a"), nil},
+	}
+
+	for i, l := range tbl {
+		if rids := vanishedResources(l.Old, l.New); !reflect.DeepEqual(rids, l.Expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, l.Expected, rids)
+		}
+	}
+}
+
+func TestRefreshAfterShutdown(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer ts.Close()
+
+	s, ep := testService(t, ts.URL, ResourceCfg{Type: "model", Pattern: "item"})
+	cresp := ep.cacheURL(ts.URL, nil)
+	s.cancel()
+	ep.refresh(ts.URL)
+
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected no refresh request after shutdown, but got %d requests", n)
+	}
+	if cresp.reloads != 0 || !cresp.staleSince.IsZero() {
+		t.Errorf("expected cached response to be left untouched")
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type format byte
+
+const (
+	formatAuto format = iota
+	formatJSON
+	formatXML
+	formatCSV
+	formatYAML
+)
+
+// xmlAttrPrefix is the prefix used for keys of XML attributes.
+const xmlAttrPrefix = "@"
+
+// xmlTextKey is the key used for XML character data within elements that
+// also has attributes or child elements.
+const xmlTextKey = "#text"
+
+func parseFormat(s string) (format, error) {
+	switch s {
+	case "":
+		return formatAuto, nil
+	case "json":
+		return formatJSON, nil
+	case "xml":
+		return formatXML, nil
+	case "csv":
+		return formatCSV, nil
+	case "yaml":
+		return formatYAML, nil
+	}
+	return formatAuto, fmt.Errorf("invalid format: %s", s)
+}
+
+// formatFromContentType returns the format for a Content-Type header value,
+// defaulting to JSON.
+func formatFromContentType(contentType string) format {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return formatJSON
+	}
+	switch {
+	case mt == "text/xml" || mt == "application/xml" || strings.HasSuffix(mt, "+xml"):
+		return formatXML
+	case mt == "text/csv":
+		return formatCSV
+	case mt == "application/yaml" || mt == "application/x-yaml" || mt == "text/yaml" || mt == "text/x-yaml":
+		return formatYAML
+	}
+	return formatJSON
+}
+
+// decode decodes the body into a value. If the format is formatAuto, the
+// format is determined by the content type.
+func (f format) decode(body []byte, contentType string) (value, error) {
+	if f == formatAuto {
+		f = formatFromContentType(contentType)
+	}
+
+	var v value
+	var err error
+	switch f {
+	case formatXML:
+		v, err = decodeXML(body)
+	case formatCSV:
+		v, err = decodeCSV(body)
+	case formatYAML:
+		v, err = decodeYAML(body)
+	default:
+		err = json.Unmarshal(body, &v)
+	}
+	return v, err
+}
+
+// decodeCSV decodes CSV data into an array of objects, using the header
+// row for the object keys. All values are strings.
+func decodeCSV(body []byte) (value, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	records, err := r.ReadAll()
+	if err != nil {
+		return value{}, err
+	}
+	if len(records) == 0 {
+		return value{}, errors.New("missing csv header row")
+	}
+
+	header := records[0]
+	arr := make([]value, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		obj := make(map[string]value, len(header))
+		for i, k := range header {
+			obj[k] = stringValue(rec[i])
+		}
+		arr = append(arr, value{typ: valueTypeObject, obj: obj})
+	}
+	return value{typ: valueTypeArray, arr: arr}, nil
+}
+
+// decodeYAML decodes YAML data into a value.
+func decodeYAML(body []byte) (value, error) {
+	var i interface{}
+	if err := yaml.Unmarshal(body, &i); err != nil {
+		return value{}, err
+	}
+	return valueOf(i)
+}
+
+// decodeXML decodes XML data into a value, where the document element
+// becomes the root value.
+//
+// Elements with neither attributes nor child elements become strings.
+// Other elements become objects, with attributes keyed by their name
+// prefixed with @, and child elements keyed by their name. Child elements
+// with the same name are grouped into an array. Any character data of an
+// element with child elements or attributes is keyed as #text.
+func decodeXML(body []byte) (value, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		t, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return value{}, errors.New("missing xml document element")
+			}
+			return value{}, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return decodeXMLElement(d, se)
+		}
+	}
+}
+
+func decodeXMLElement(d *xml.Decoder, se xml.StartElement) (value, error) {
+	obj := make(map[string]value)
+	for _, attr := range se.Attr {
+		obj[xmlAttrPrefix+attr.Name.Local] = stringValue(attr.Value)
+	}
+
+	var text strings.Builder
+	var children []string
+	grouped := make(map[string][]value)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return value{}, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			cv, err := decodeXMLElement(d, t)
+			if err != nil {
+				return value{}, err
+			}
+			name := t.Name.Local
+			if _, ok := grouped[name]; !ok {
+				children = append(children, name)
+			}
+			grouped[name] = append(grouped[name], cv)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(obj) == 0 && len(children) == 0 {
+				v := stringValue(s)
+				v.xml = true
+				return v, nil
+			}
+			for _, name := range children {
+				vs := grouped[name]
+				if len(vs) == 1 {
+					obj[name] = vs[0]
+				} else {
+					obj[name] = value{typ: valueTypeArray, arr: vs, xml: true}
+				}
+			}
+			if s != "" {
+				obj[xmlTextKey] = stringValue(s)
+			}
+			return value{typ: valueTypeObject, obj: obj, xml: true}, nil
+		}
+	}
+}
+
+// stringValue returns a string value.
+func stringValue(s string) value {
+	raw, _ := json.Marshal(s)
+	return value{typ: valueTypeString, raw: raw}
+}
+
+// valueOf converts decoded data, such as from YAML, into a value.
+func valueOf(i interface{}) (value, error) {
+	switch t := i.(type) {
+	case nil:
+		return value{typ: valueTypeNull}, nil
+	case bool:
+		if t {
+			return value{typ: valueTypeTrue}, nil
+		}
+		return value{typ: valueTypeFalse}, nil
+	case string:
+		return stringValue(t), nil
+	case int:
+		return value{typ: valueTypeNumber, raw: json.RawMessage(strconv.Itoa(t))}, nil
+	case int64:
+		return value{typ: valueTypeNumber, raw: json.RawMessage(strconv.FormatInt(t, 10))}, nil
+	case uint64:
+		return value{typ: valueTypeNumber, raw: json.RawMessage(strconv.FormatUint(t, 10))}, nil
+	case float64:
+		raw, err := json.Marshal(t)
+		if err != nil {
+			return value{}, err
+		}
+		return value{typ: valueTypeNumber, raw: raw}, nil
+	case time.Time:
+		return stringValue(t.Format(time.RFC3339Nano)), nil
+	case []interface{}:
+		arr := make([]value, len(t))
+		for j, e := range t {
+			v, err := valueOf(e)
+			if err != nil {
+				return value{}, err
+			}
+			arr[j] = v
+		}
+		return value{typ: valueTypeArray, arr: arr}, nil
+	case map[interface{}]interface{}:
+		obj := make(map[string]value, len(t))
+		for k, e := range t {
+			v, err := valueOf(e)
+			if err != nil {
+				return value{}, err
+			}
+			obj[fmt.Sprint(k)] = v
+		}
+		return value{typ: valueTypeObject, obj: obj}, nil
+	case map[string]interface{}:
+		obj := make(map[string]value, len(t))
+		for k, e := range t {
+			v, err := valueOf(e)
+			if err != nil {
+				return value{}, err
+			}
+			obj[k] = v
+		}
+		return value{typ: valueTypeObject, obj: obj}, nil
+	}
+	return value{}, fmt.Errorf("unsupported value type %T", i)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// valueJSON encodes a value, including any nested objects and arrays, into
+// an interface{} for comparison.
+func valueJSON(v value) interface{} {
+	switch v.typ {
+	case valueTypeObject:
+		m := make(map[string]interface{}, len(v.obj))
+		for k, e := range v.obj {
+			m[k] = valueJSON(e)
+		}
+		return m
+	case valueTypeArray:
+		a := make([]interface{}, len(v.arr))
+		for i, e := range v.arr {
+			a[i] = valueJSON(e)
+		}
+		return a
+	}
+	b, _ := v.MarshalJSON()
+	var i interface{}
+	json.Unmarshal(b, &i)
+	return i
+}
+
+func TestFormatDecode(t *testing.T) {
+	tbl := []struct {
+		Format      string
+		ContentType string
+		Body        string
+		Expected    string
+	}{
+		{"", "application/json", `{"id":1,"name":"foo"}`, `{"id":1,"name":"foo"}`},
+		{"", "", `[1,2]`, `[1,2]`},
+		{"json", "text/xml", `{"id":1}`, `{"id":1}`},
+		// XML
+		{"xml", "", `<station><id>1</id><name>Foo</name></station>`, `{"id":"1","name":"Foo"}`},
+		{"", "application/xml; charset=utf-8", `<a id="1">text</a>`, `{"@id":"1","#text":"text"}`},
+		{"", "application/atom+xml", `<list><item>a</item><item>b</item></list>`, `{"item":["a","b"]}`},
+		{"xml", "", `<?xml version="1.0"?><list><item id="1"/><item id="2"><name>x</name></item></list>`, `{"item":[{"@id":"1"},{"@id":"2","name":"x"}]}`},
+		{"xml", "", `<empty/>`, `""`},
+		// CSV
+		{"csv", "", "id,name\n1,Foo\n2,\"Bar, Baz\"\n", `[{"id":"1","name":"Foo"},{"id":"2","name":"Bar, Baz"}]`},
+		{"", "text/csv", "id,name\n", `[]`},
+		// YAML
+		{"yaml", "", "id: 1\nname: foo\ntags:\n  - a\n  - b\nnested:\n  ok: true\n  none: null\n", `{"id":1,"name":"foo","tags":["a","b"],"nested":{"ok":true,"none":null}}`},
+		{"", "application/x-yaml", "- 1.5\n- 2\n", `[1.5,2]`},
+		{"yaml", "", "1: one\n", `{"1":"one"}`},
+	}
+
+	for i, l := range tbl {
+		f, err := parseFormat(l.Format)
+		AssertNoError(t, err)
+		v, err := f.decode([]byte(l.Body), l.ContentType)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %s", i, err)
+			continue
+		}
+		var expected interface{}
+		AssertNoError(t, json.Unmarshal([]byte(l.Expected), &expected))
+		got, _ := json.Marshal(valueJSON(v))
+		exp, _ := json.Marshal(expected)
+		if string(got) != string(exp) {
+			t.Errorf("test %d: expected %s, but got %s", i, exp, got)
+		}
+	}
+}
+
+func TestFormatDecodeErrors(t *testing.T) {
+	tbl := []struct {
+		Format string
+		Body   string
+	}{
+		{"json", `{"id":`},
+		{"xml", `<a><b></a>`},
+		{"xml", ``},
+		{"csv", ``},
+		{"csv", "id,name\n1\n"},
+		{"yaml", "id: [1"},
+	}
+
+	for i, l := range tbl {
+		f, err := parseFormat(l.Format)
+		AssertNoError(t, err)
+		if _, err := f.decode([]byte(l.Body), ""); err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+
+	if _, err := parseFormat("toml"); err == nil {
+		t.Errorf("expected an error for invalid format, but got none")
+	}
+}
+
+func TestTraverseXMLLists(t *testing.T) {
+	root := &node{}
+	AssertNoError(t, root.addPath("", "station", nil, "model", ""))
+	AssertNoError(t, root.addPath("stops", "station.stops", nil, "model", ""))
+	AssertNoError(t, root.addPath("stops.stop", "station.stops.stop", nil, "collection", ""))
+	AssertNoError(t, root.addPath("stops.stop.$id", "station.stops.stop.$id", nil, "model", "id"))
+	AssertNoError(t, root.addPath("tag", "station.tag", nil, "collection", ""))
+
+	tbl := []struct {
+		Body  string
+		Stops int
+		Tags  int
+	}{
+		{`<station><stops><stop><id>1</id></stop><stop><id>2</id></stop></stops><tag>a</tag><tag>b</tag></station>`, 2, 2},
+		{`<station><stops><stop><id>1</id></stop></stops><tag>a</tag></station>`, 1, 1},
+		{`<station><stops><stop><id>1</id></stop></stops><tag></tag></station>`, 1, 0},
+	}
+
+	for i, l := range tbl {
+		v, err := formatXML.decode([]byte(l.Body), "")
+		AssertNoError(t, err)
+		crs := make(map[string]cachedResource)
+		if _, err := traverseModel(crs, v, nil, root, nil, ""); err != nil {
+			t.Errorf("test %d: expected no error, but got %s", i, err)
+			continue
+		}
+		if n := len(crs["station.stops.stop"].collection); n != l.Stops {
+			t.Errorf("test %d: expected %d stops, but got %d", i, l.Stops, n)
+		}
+		if n := len(crs["station.tag"].collection); n != l.Tags {
+			t.Errorf("test %d: expected %d tags, but got %d", i, l.Tags, n)
+		}
+	}
+}
+
+func TestXMLLookupList(t *testing.T) {
+	tbl := []struct {
+		Body     string
+		Expected int
+	}{
+		{`<list><item><id>1</id></item><item><id>2</id></item></list>`, 2},
+		{`<list><item><id>1</id></item></list>`, 1},
+		{`<list></list>`, 0},
+		{`<list/>`, 0},
+	}
+
+	for i, l := range tbl {
+		v, err := formatXML.decode([]byte(l.Body), "")
+		AssertNoError(t, err)
+		lv, ok := v.lookupList("item")
+		if !ok {
+			t.Errorf("test %d: expected items to be found", i)
+			continue
+		}
+		if lv.typ != valueTypeArray || len(lv.arr) != l.Expected {
+			t.Errorf("test %d: expected %d items, but got %v", i, l.Expected, valueJSON(lv))
+		}
+	}
+}
+
+func TestTraverseJSONListsNotWrapped(t *testing.T) {
+	root := &node{}
+	AssertNoError(t, root.addPath("", "station", nil, "model", ""))
+	AssertNoError(t, root.addPath("items", "station.items", nil, "collection", ""))
+
+	for i, body := range []string{`{"items":{"a":1}}`, `{"items":5}`, `{"items":""}`} {
+		v, err := formatJSON.decode([]byte(body), "")
+		AssertNoError(t, err)
+		if _, err := traverseModel(make(map[string]cachedResource), v, nil, root, nil, ""); err == nil {
+			t.Errorf("test %d: expected an error for %s, but got none", i, body)
+		}
+	}
+
+	tbl := []struct {
+		Body string
+		Path string
+	}{
+		{`{"item":{"id":1}}`, "item"},
+		{`{"item":5}`, "item"},
+		{`{"item":""}`, "item"},
+		{`{"list":""}`, "list.item"},
+	}
+	for i, l := range tbl {
+		v, err := formatJSON.decode([]byte(l.Body), "")
+		AssertNoError(t, err)
+		if lv, ok := v.lookupList(l.Path); ok {
+			t.Errorf("test %d: expected no list at %s, but got %v", i, l.Path, valueJSON(lv))
+		}
+	}
+}
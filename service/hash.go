@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"sort"
+)
+
+// A resourceHash is a hash of the content of a cached resource. The zero
+// value means the content is not hashed.
+type resourceHash [16]byte
+
+// sameContent reports whether the cached resources are known to have the
+// same content, by comparing their content hashes.
+func (cr cachedResource) sameContent(ncr cachedResource) bool {
+	return cr.hash != resourceHash{} && cr.hash == ncr.hash
+}
+
+// hashModel returns the content hash of a model, or the zero value if any
+// property value cannot be hashed.
+func hashModel(m map[string]interface{}) resourceHash {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New128a()
+	h.Write([]byte{byte(resourceTypeModel)})
+	for _, k := range keys {
+		id, ok := elementID(m[k])
+		if !ok {
+			return resourceHash{}
+		}
+		writeHashString(h, k)
+		writeHashString(h, id)
+	}
+	return sumHash(h)
+}
+
+// hashCollection returns the content hash of a collection, or the zero
+// value if any element cannot be hashed.
+func hashCollection(c []interface{}) resourceHash {
+	h := fnv.New128a()
+	h.Write([]byte{byte(resourceTypeCollection)})
+	for _, e := range c {
+		id, ok := elementID(e)
+		if !ok {
+			return resourceHash{}
+		}
+		writeHashString(h, id)
+	}
+	return sumHash(h)
+}
+
+// writeHashString writes the length prefixed string to the hash.
+func writeHashString(h hash.Hash, s string) {
+	var buf [binary.MaxVarintLen64]byte
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+	h.Write([]byte(s))
+}
+
+func sumHash(h hash.Hash) resourceHash {
+	var rh resourceHash
+	copy(rh[:], h.Sum(nil))
+	return rh
+}
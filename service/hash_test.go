@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	res "github.com/jirenius/go-res"
+)
+
+func TestHashModel(t *testing.T) {
+	model := func(s string) map[string]interface{} {
+		var v value
+		AssertNoError(t, json.Unmarshal([]byte(s), &v))
+		crs := make(map[string]cachedResource)
+		root := &node{}
+		AssertNoError(t, root.addPath("", "s", nil, "model", ""))
+		root.unmapped = unmappedData
+		_, err := traverseModel(crs, v, nil, root, nil, "")
+		AssertNoError(t, err)
+		return crs["s"].model
+	}
+
+	tbl := []struct {
+		A     string
+		B     string
+		Equal bool
+	}{
+		{`{"a":1,"b":"x"}`, `{"b":"x","a":1}`, true},
+		{`{"a":1,"d":{"x":1,"y":[1,2]}}`, `{"d":{"y":[1,2],"x":1},"a":1}`, true},
+		{`{"a":1}`, `{"a":2}`, false},
+		{`{"a":1}`, `{"a":"1"}`, false},
+		{`{"a":1}`, `{"b":1}`, false},
+		{`{"a":null}`, `{}`, false},
+		{`{"ab":"c"}`, `{"a":"bc"}`, false},
+		{`{"d":{"x":1}}`, `{"d":{"x":2}}`, false},
+	}
+
+	for i, l := range tbl {
+		ha := hashModel(model(l.A))
+		hb := hashModel(model(l.B))
+		if (ha == hb) != l.Equal {
+			t.Errorf("test %d: expected hash equality to be %v", i, l.Equal)
+		}
+	}
+}
+
+func TestHashCollection(t *testing.T) {
+	a := []interface{}{res.Ref("s.a"), res.Ref("s.b")}
+	if hashCollection(a) != hashCollection([]interface{}{res.Ref("s.a"), res.Ref("s.b")}) {
+		t.Errorf("expected equal hashes")
+	}
+	if hashCollection(a) == hashCollection([]interface{}{res.Ref("s.b"), res.Ref("s.a")}) {
+		t.Errorf("expected different hashes for reordered collection")
+	}
+	if hashCollection(nil) == hashModel(nil) {
+		t.Errorf("expected different hashes for empty model and collection")
+	}
+}
+
+func TestCachedResourceSameContent(t *testing.T) {
+	cr := cachedResource{typ: resourceTypeCollection, hash: hashCollection(nil)}
+	if !cr.sameContent(cr) {
+		t.Errorf("expected same content")
+	}
+	var unhashed cachedResource
+	if unhashed.sameContent(unhashed) {
+		t.Errorf("expected unhashed resources to not be considered the same")
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// freshness returns the duration a response remains fresh, based on the
+// Cache-Control max-age directive or the Expires header. The bool is false
+// if the headers contain no freshness information.
+func freshness(h http.Header, now time.Time) (time.Duration, bool) {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		maxAge := -1
+		for _, d := range strings.Split(cc, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			switch {
+			case d == "no-cache" || d == "no-store":
+				return 0, true
+			case strings.HasPrefix(d, "max-age="):
+				secs, err := strconv.Atoi(strings.Trim(d[len("max-age="):], `"`))
+				if err == nil && secs >= 0 {
+					maxAge = secs
+				}
+			}
+		}
+		if maxAge >= 0 {
+			d := time.Duration(maxAge) * time.Second
+			if age, err := strconv.Atoi(h.Get("Age")); err == nil && age > 0 {
+				d -= time.Duration(age) * time.Second
+			}
+			return d, true
+		}
+	}
+
+	if exp := h.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			// Invalid dates represent a time in the past
+			return 0, true
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		return t.Sub(date), true
+	}
+
+	return 0, false
+}
+
+// retryAfter returns the duration to wait as given by the Retry-After
+// header, either as delay seconds or as a HTTP date. The bool is false if
+// the header is missing or invalid.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	ra := strings.TrimSpace(h.Get("Retry-After"))
+	if ra == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(ra); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(ra)
+	if err != nil {
+		return 0, false
+	}
+	return t.Sub(now), true
+}
+
+// rateLimitRemaining returns the number of requests remaining as given by
+// the X-RateLimit-Remaining or RateLimit-Remaining header. The bool is false
+// if no header is set.
+func rateLimitRemaining(h http.Header) (int, bool) {
+	for _, k := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+		if n, err := strconv.Atoi(strings.TrimSpace(h.Get(k))); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the duration until the rate limit resets, as given
+// by the X-RateLimit-Reset or RateLimit-Reset header. The value may be
+// either delta seconds or a unix timestamp. The bool is false if no
+// header is set.
+func rateLimitReset(h http.Header, now time.Time) (time.Duration, bool) {
+	for _, k := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		n, err := strconv.ParseInt(strings.TrimSpace(h.Get(k)), 10, 64)
+		if err != nil || n < 0 {
+			continue
+		}
+		// Values larger than a year in seconds are considered timestamps
+		if n > 365*24*60*60 {
+			d := time.Unix(n, 0).Sub(now)
+			if d < 0 {
+				d = 0
+			}
+			return d, true
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	return 0, false
+}
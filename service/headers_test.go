@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tbl := []struct {
+		Header   http.Header
+		Expected time.Duration
+		OK       bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"Cache-Control": {"public"}}, 0, false},
+		{http.Header{"Cache-Control": {"public, max-age=60"}}, 60 * time.Second, true},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, 40 * time.Second, true},
+		{http.Header{"Cache-Control": {"no-cache"}}, 0, true},
+		{http.Header{"Expires": {now.Add(90 * time.Second).Format(http.TimeFormat)}, "Date": {date}}, 90 * time.Second, true},
+		{http.Header{"Expires": {"0"}}, 0, true},
+		{http.Header{"Cache-Control": {"max-age=10"}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, 10 * time.Second, true},
+	}
+
+	for i, l := range tbl {
+		d, ok := freshness(l.Header, now)
+		if d != l.Expected || ok != l.OK {
+			t.Errorf("#%d expected %s, %t, but got %s, %t", i+1, l.Expected, l.OK, d, ok)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		Value    string
+		Expected time.Duration
+		OK       bool
+	}{
+		{"", 0, false},
+		{"120", 120 * time.Second, true},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{"soon", 0, false},
+	}
+
+	for i, l := range tbl {
+		d, ok := retryAfter(http.Header{"Retry-After": {l.Value}}, now)
+		if d != l.Expected || ok != l.OK {
+			t.Errorf("#%d expected %s, %t, but got %s, %t", i+1, l.Expected, l.OK, d, ok)
+		}
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		Header   http.Header
+		Expected time.Duration
+		OK       bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"X-Ratelimit-Reset": {"30"}}, 30 * time.Second, true},
+		{http.Header{"Ratelimit-Reset": {"15"}}, 15 * time.Second, true},
+		{http.Header{"X-Ratelimit-Reset": {"1551441660"}}, 60 * time.Second, true},
+		{http.Header{"X-Ratelimit-Reset": {"1551441000"}}, 0, true},
+		{http.Header{"X-Ratelimit-Reset": {"later"}}, 0, false},
+	}
+
+	for i, l := range tbl {
+		d, ok := rateLimitReset(l.Header, now)
+		if d != l.Expected || ok != l.OK {
+			t.Errorf("#%d expected %s, %t, but got %s, %t", i+1, l.Expected, l.OK, d, ok)
+		}
+	}
+}
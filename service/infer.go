@@ -0,0 +1,247 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// idPropCandidates are the property names, in order of preference, that
+// are guessed to be the ID property of objects within an array.
+var idPropCandidates = []string{"id", "_id", "ID", "Id", "uuid", "key", "code"}
+
+// InferEndpoint proposes an endpoint config for the url, based on a sample
+// response body of the endpoint. The format is either a valid endpoint
+// format, or empty to detect it from the content type. If root is set, it
+// selects the data within the response to map as the endpoint resource.
+func InferEndpoint(rawurl string, body []byte, format, contentType, root string) (EndpointCfg, error) {
+	params, err := urlParams(rawurl)
+	if err != nil {
+		return EndpointCfg{}, fmt.Errorf("invalid url: %s", err)
+	}
+
+	f, err := parseFormat(format)
+	if err != nil {
+		return EndpointCfg{}, err
+	}
+	v, err := f.decode(body, contentType)
+	if err != nil {
+		return EndpointCfg{}, fmt.Errorf("error decoding response: %s", err)
+	}
+	if root != "" {
+		var ok bool
+		if v, ok = v.lookup(root); !ok {
+			return EndpointCfg{}, fmt.Errorf("missing root %s", root)
+		}
+	}
+	if v.typ != valueTypeObject && v.typ != valueTypeArray {
+		return EndpointCfg{}, errors.New("response is not an object or array")
+	}
+
+	inf := &inferrer{params: make(map[string]bool)}
+	for _, p := range params {
+		inf.params[p] = true
+	}
+
+	cfg := Config{Endpoints: []EndpointCfg{{
+		URL:         rawurl,
+		Format:      format,
+		Root:        root,
+		ResourceCfg: inf.resource(v, "", ""),
+	}}}
+	cfg.Endpoints[0].Pattern = inferPattern(rawurl, params)
+	cfg.SetDefault()
+	return cfg.Endpoints[0], nil
+}
+
+// inferrer keeps track of the pattern parameter names in use while
+// inferring resources.
+type inferrer struct {
+	params map[string]bool
+}
+
+// resource returns the resource config for an object or array value.
+func (inf *inferrer) resource(v value, path, name string) ResourceCfg {
+	r := ResourceCfg{Path: path}
+	switch v.typ {
+	case valueTypeObject:
+		r.Type = "model"
+		keys := make([]string, 0, len(v.obj))
+		for k := range v.obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kv := v.obj[k]
+			if (kv.typ != valueTypeObject && kv.typ != valueTypeArray) || !validPathPart(k) {
+				continue
+			}
+			r.Resources = append(r.Resources, inf.resource(kv, k, k))
+		}
+	case valueTypeArray:
+		r.Type = "collection"
+		ev, ok := mergeElements(v.arr)
+		if !ok {
+			break
+		}
+		if name == "" {
+			name = "item"
+		}
+		param := inf.param(name + "Id")
+		er := inf.resource(ev, "$"+param, param)
+		if ev.typ == valueTypeObject {
+			er.IDProp = guessIDProp(v.arr)
+		}
+		r.Resources = append(r.Resources, er)
+	}
+	return r
+}
+
+// param returns an unused pattern parameter name based on name.
+func (inf *inferrer) param(name string) string {
+	p := name
+	for i := 2; inf.params[p]; i++ {
+		p = name + strconv.Itoa(i)
+	}
+	inf.params[p] = true
+	return p
+}
+
+// mergeElements merges the elements of an array into a single value
+// describing the structure of all elements. The bool is false if the
+// array has no elements, if the elements are primitive values, or if the
+// elements are not all of the same type.
+func mergeElements(arr []value) (value, bool) {
+	if len(arr) == 0 {
+		return value{}, false
+	}
+	typ := arr[0].typ
+	if typ != valueTypeObject && typ != valueTypeArray {
+		return value{}, false
+	}
+	for _, e := range arr {
+		if e.typ != typ {
+			return value{}, false
+		}
+	}
+
+	if typ == valueTypeArray {
+		var all []value
+		for _, e := range arr {
+			all = append(all, e.arr...)
+		}
+		return value{typ: valueTypeArray, arr: all}, true
+	}
+
+	obj := make(map[string]value)
+	for _, e := range arr {
+		for k, kv := range e.obj {
+			if prev, ok := obj[k]; ok && (prev.typ == valueTypeObject || prev.typ == valueTypeArray) {
+				continue
+			}
+			obj[k] = kv
+		}
+	}
+	return value{typ: valueTypeObject, obj: obj}, true
+}
+
+// guessIDProp returns the first ID property candidate that has a unique
+// string or number value in each object of the array, or an empty string
+// if none is found.
+func guessIDProp(arr []value) string {
+Candidates:
+	for _, c := range idPropCandidates {
+		seen := make(map[string]bool, len(arr))
+		for _, e := range arr {
+			idv, ok := e.obj[c]
+			if !ok || (idv.typ != valueTypeString && idv.typ != valueTypeNumber) {
+				continue Candidates
+			}
+			id := string(idv.raw)
+			if idv.typ == valueTypeString {
+				var s string
+				if json.Unmarshal(idv.raw, &s) != nil || s == "" {
+					continue Candidates
+				}
+				id = s
+			}
+			if seen[id] {
+				continue Candidates
+			}
+			seen[id] = true
+		}
+		return c
+	}
+	return ""
+}
+
+// inferPattern returns a resource pattern based on the path of the url,
+// with placeholders for all url parameters.
+func inferPattern(rawurl string, params []string) string {
+	p := rawurl
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+3:]
+		if i = strings.IndexByte(p, '/'); i >= 0 {
+			p = p[i:]
+		} else {
+			p = ""
+		}
+	}
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+
+	var tokens []string
+	covered := make(map[string]bool, len(params))
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" {
+			continue
+		}
+		segParams, _ := urlParams(seg)
+		if len(segParams) > 0 {
+			for _, sp := range segParams {
+				tokens = append(tokens, "$"+sp)
+				covered[sp] = true
+			}
+			continue
+		}
+		if i := strings.LastIndexByte(seg, '.'); i > 0 {
+			// Strip file extensions, such as .json
+			seg = seg[:i]
+		}
+		if t := patternToken(seg); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	for _, p := range params {
+		if !covered[p] {
+			tokens = append(tokens, "$"+p)
+			covered[p] = true
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+// patternToken replaces any characters not allowed in a resource name
+// token with underscores.
+func patternToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', '$', '?', ' ', '\t', '\n', '\r':
+			return '_'
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// validPathPart reports whether a property key may be used as a part of a
+// resource path.
+func validPathPart(k string) bool {
+	return k != "" && k[0] != pmark && !strings.Contains(k, btsep)
+}
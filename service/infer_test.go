@@ -0,0 +1,88 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInferEndpoint(t *testing.T) {
+	body := []byte(`{
+		"name": "Central",
+		"location": {"lat": 1.5, "lng": 2},
+		"platforms": [
+			{"id": 1, "tracks": [{"code": "A"}, {"code": "B"}]},
+			{"id": 2, "tracks": []}
+		],
+		"tags": ["a", "b"],
+		"my.key": {"a": 1}
+	}`)
+	cep, err := InferEndpoint("http://example.com/api/stations/${stationId}.json?lang=${lang}", body, "", "application/json", "")
+	AssertNoError(t, err)
+
+	if cep.Pattern != "api.stations.$stationId.$lang" {
+		t.Errorf("unexpected pattern: %s", cep.Pattern)
+	}
+	if cep.RefreshTime == 0 {
+		t.Errorf("expected default settings to be set")
+	}
+
+	expected := ResourceCfg{
+		Type:    "model",
+		Pattern: "api.stations.$stationId.$lang",
+		Resources: []ResourceCfg{
+			{Type: "model", Path: "location"},
+			{Type: "collection", Path: "platforms", Resources: []ResourceCfg{
+				{Type: "model", Path: "$platformsId", IDProp: "id", Resources: []ResourceCfg{
+					{Type: "collection", Path: "tracks", Resources: []ResourceCfg{
+						{Type: "model", Path: "$tracksId", IDProp: "code"},
+					}},
+				}},
+			}},
+			{Type: "collection", Path: "tags"},
+		},
+	}
+	if !reflect.DeepEqual(cep.ResourceCfg, expected) {
+		t.Errorf("expected resources:\n\t%#v\nbut got:\n\t%#v", expected, cep.ResourceCfg)
+	}
+}
+
+func TestInferEndpointCollection(t *testing.T) {
+	cep, err := InferEndpoint("http://example.com/items", []byte(`{"data":[{"_id":"x"},{"_id":"y","id":1}]}`), "json", "", "data")
+	AssertNoError(t, err)
+
+	expected := ResourceCfg{
+		Type:    "collection",
+		Pattern: "items",
+		Resources: []ResourceCfg{
+			{Type: "model", Path: "$itemId", IDProp: "_id"},
+		},
+	}
+	if !reflect.DeepEqual(cep.ResourceCfg, expected) {
+		t.Errorf("expected resources:\n\t%#v\nbut got:\n\t%#v", expected, cep.ResourceCfg)
+	}
+	if cep.Root != "data" {
+		t.Errorf("expected root to be data, but got %s", cep.Root)
+	}
+}
+
+func TestGuessIDProp(t *testing.T) {
+	tbl := []struct {
+		Data     string
+		Expected string
+	}{
+		{`[{"id":1},{"id":2}]`, "id"},
+		{`[{"id":1},{"id":1,"_id":"b"}]`, ""},
+		{`[{"id":1,"_id":"a"},{"id":1,"_id":"b"}]`, "_id"},
+		{`[{"id":""},{"id":"a"}]`, ""},
+		{`[{"id":{}},{"id":"a"}]`, ""},
+		{`[{"name":"a"}]`, ""},
+	}
+
+	for i, l := range tbl {
+		var v value
+		AssertNoError(t, v.UnmarshalJSON([]byte(l.Data)))
+		if got := guessIDProp(v.arr); got != l.Expected {
+			t.Errorf("test %d: expected %#v, but got %#v", i, l.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+)
+
+// NatsCfg holds the settings for connecting to NATS Server. String values
+// for passwords and tokens may contain ${env:NAME} and ${file:PATH} tags.
+type NatsCfg struct {
+	Credentials   string   `json:"credentials,omitempty"`
+	NKeySeed      string   `json:"nkeySeed,omitempty"`
+	User          string   `json:"user,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	Token         string   `json:"token,omitempty"`
+	TLSCert       string   `json:"tlsCert,omitempty"`
+	TLSKey        string   `json:"tlsKey,omitempty"`
+	RootCAs       []string `json:"rootCAs,omitempty"`
+	ReconnectWait int      `json:"reconnectWait,omitempty"`
+	MaxReconnects int      `json:"maxReconnects,omitempty"`
+	Name          string   `json:"name,omitempty"`
+}
+
+// Options returns the NATS connection options for the config.
+func (c *NatsCfg) Options() ([]nats.Option, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	var opts []nats.Option
+	if c.Name != "" {
+		opts = append(opts, nats.Name(c.Name))
+	}
+
+	// Authentication
+	if c.Credentials != "" && c.NKeySeed != "" {
+		return nil, errors.New("credentials and nkeySeed must not both be set")
+	}
+	if c.Token != "" && (c.User != "" || c.Password != "") {
+		return nil, errors.New("token must not be set together with user and password")
+	}
+	if c.Credentials != "" {
+		opts = append(opts, nats.UserCredentials(c.Credentials))
+	}
+	if c.NKeySeed != "" {
+		opt, err := nats.NkeyOptionFromSeed(c.NKeySeed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nkeySeed: %s", err)
+		}
+		opts = append(opts, opt)
+	}
+	if c.User != "" || c.Password != "" {
+		pw, err := expandSecrets(c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("invalid password: %s", err)
+		}
+		opts = append(opts, nats.UserInfo(c.User, pw))
+	}
+	if c.Token != "" {
+		token, err := expandSecrets(c.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token: %s", err)
+		}
+		opts = append(opts, nats.Token(token))
+	}
+
+	// TLS
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return nil, errors.New("tlsCert and tlsKey must both be set")
+	}
+	if c.TLSCert != "" {
+		opts = append(opts, nats.ClientCert(c.TLSCert, c.TLSKey))
+	}
+	if len(c.RootCAs) > 0 {
+		opts = append(opts, nats.RootCAs(c.RootCAs...))
+	}
+
+	// Reconnect
+	if c.ReconnectWait < 0 {
+		return nil, errors.New("reconnectWait must not be negative")
+	}
+	if c.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(time.Millisecond*time.Duration(c.ReconnectWait)))
+	}
+	if c.MaxReconnects != 0 {
+		opts = append(opts, nats.MaxReconnects(c.MaxReconnects))
+	}
+
+	return opts, nil
+}
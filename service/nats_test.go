@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestNatsCfgOptions(t *testing.T) {
+	tbl := []struct {
+		Cfg   *NatsCfg
+		Count int
+	}{
+		{nil, 0},
+		{&NatsCfg{}, 0},
+		{&NatsCfg{Name: "rest2res", Credentials: "user.creds"}, 2},
+		{&NatsCfg{User: "foo", Password: "bar", ReconnectWait: 500, MaxReconnects: -1}, 3},
+		{&NatsCfg{Token: "secret", TLSCert: "cert.pem", TLSKey: "key.pem", RootCAs: []string{"ca.pem"}}, 3},
+	}
+
+	for i, l := range tbl {
+		opts, err := l.Cfg.Options()
+		AssertNoError(t, err)
+		if len(opts) != l.Count {
+			t.Errorf("test %d: expected %d options, but got %d", i, l.Count, len(opts))
+		}
+	}
+}
+
+func TestNatsCfgOptionsErrors(t *testing.T) {
+	tbl := []*NatsCfg{
+		{Credentials: "user.creds", NKeySeed: "seed.nk"},
+		{User: "foo", Token: "secret"},
+		{TLSCert: "cert.pem"},
+		{TLSKey: "key.pem"},
+		{ReconnectWait: -1},
+		{Token: "${env:}"},
+	}
+
+	for i, l := range tbl {
+		if _, err := l.Options(); err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+}
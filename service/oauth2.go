@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauth2RefreshRatio is the part of a token's lifetime after which it is
+// proactively refreshed.
+const oauth2RefreshRatio = 0.8
+
+// oauth2RefreshTimeout is the time allowed for refreshing a token in the
+// background.
+const oauth2RefreshTimeout = 30 * time.Second
+
+// oauth2Token is an OAuth2 access token acquired using the client
+// credentials grant. It is shared by all endpoints using the same
+// credentials.
+type oauth2Token struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	s          *Service
+	mu         sync.Mutex
+	token      string
+	refreshAt  time.Time
+	expiresAt  time.Time
+	refreshing bool
+}
+
+type oauth2Auth struct {
+	t *oauth2Token
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (a *oauth2Auth) authenticate(req *http.Request) error {
+	token, err := a.t.get(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// invalidate discards the token used by the request, forcing a new token
+// to be acquired on next authentication.
+func (a *oauth2Auth) invalidate(req *http.Request) {
+	a.t.invalidate(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+}
+
+// oauth2Token returns the shared token for the credentials, creating it if
+// it does not exist.
+func (s *Service) oauth2Token(tokenURL, clientID, clientSecret string, scopes []string) *oauth2Token {
+	key := strings.Join([]string{tokenURL, clientID, clientSecret, strings.Join(scopes, " ")}, "\x00")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[key]; ok {
+		return t
+	}
+	t := &oauth2Token{
+		s:            s,
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}
+	if s.tokens == nil {
+		s.tokens = make(map[string]*oauth2Token)
+	}
+	s.tokens[key] = t
+	return t
+}
+
+// get returns a valid access token, acquiring a new one if no token is
+// cached or if the cached token has expired. A cached token due to be
+// refreshed is still returned, while a new token is acquired in the
+// background. Should the refresh fail, the token is used until it expires.
+func (t *oauth2Token) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token != "" && (t.expiresAt.IsZero() || now.Before(t.expiresAt)) {
+		if !t.refreshAt.IsZero() && !now.Before(t.refreshAt) && !t.refreshing {
+			t.refreshing = true
+			go t.refresh()
+		}
+		return t.token, nil
+	}
+
+	tr, err := t.request(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error acquiring oauth2 token: %s", err)
+	}
+	t.set(tr)
+	return t.token, nil
+}
+
+// refresh acquires a new token in the background, keeping the current
+// token if it fails.
+func (t *oauth2Token) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), oauth2RefreshTimeout)
+	defer cancel()
+	tr, err := t.request(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refreshing = false
+	if err != nil {
+		t.s.Logf("Error refreshing oauth2 token from %s:\n\t%s", t.tokenURL, err)
+		return
+	}
+	t.set(tr)
+}
+
+// set stores the token of the response, and when to refresh it.
+// Must be called with the lock held.
+func (t *oauth2Token) set(tr *oauth2TokenResponse) {
+	t.token = tr.AccessToken
+	if tr.ExpiresIn > 0 {
+		lifetime := time.Duration(tr.ExpiresIn) * time.Second
+		t.refreshAt = time.Now().Add(time.Duration(float64(lifetime) * oauth2RefreshRatio))
+		t.expiresAt = time.Now().Add(lifetime)
+	} else {
+		t.refreshAt = time.Time{}
+		t.expiresAt = time.Time{}
+	}
+}
+
+// invalidate discards the cached token, unless it has already been
+// replaced by another token.
+func (t *oauth2Token) invalidate(token string) {
+	t.mu.Lock()
+	if t.token == token {
+		t.token = ""
+	}
+	t.mu.Unlock()
+}
+
+func (t *oauth2Token) request(ctx context.Context) (*oauth2TokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.scopes) > 0 {
+		form.Set("scope", strings.Join(t.scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(t.clientID), url.QueryEscape(t.clientSecret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	var tr oauth2TokenResponse
+	if err = json.Unmarshal(body, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("missing access_token in response")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported token type: %s", tr.TokenType)
+	}
+	return &tr, nil
+}
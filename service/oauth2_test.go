@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer returns a test OAuth2 token server, issuing the tokens
+// token-1, token-2, and so on, and a counter of the token requests made.
+func tokenServer(t *testing.T, delay time.Duration, expiresIn int) (*httptest.Server, *int32) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" {
+			t.Errorf("expected grant_type client_credentials, but got %q", r.FormValue("grant_type"))
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			t.Errorf("expected basic auth with client credentials, but got %q, %q", id, secret)
+		}
+		n := atomic.AddInt32(&count, 1)
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	return ts, &count
+}
+
+func TestOAuth2TokenSharedByConcurrentCallers(t *testing.T) {
+	ts, count := tokenServer(t, 50*time.Millisecond, 3600)
+	defer ts.Close()
+
+	tok := (&Service{}).oauth2Token(ts.URL, "client", "secret", nil)
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := tok.get(context.Background())
+			AssertNoError(t, err)
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(count); n != 1 {
+		t.Errorf("expected 1 token request, but got %d", n)
+	}
+	for i, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("expected caller %d to get token-1, but got %q", i, token)
+		}
+	}
+}
+
+func TestOAuth2TokenSharedByCredentials(t *testing.T) {
+	s := &Service{}
+	a := s.oauth2Token("http://example.com/token", "client", "secret", []string{"read"})
+	if b := s.oauth2Token("http://example.com/token", "client", "secret", []string{"read"}); a != b {
+		t.Errorf("expected same credentials to share token")
+	}
+	if b := s.oauth2Token("http://example.com/token", "client", "other", []string{"read"}); a == b {
+		t.Errorf("expected different secret to not share token")
+	}
+	if b := s.oauth2Token("http://example.com/token", "client", "secret", []string{"write"}); a == b {
+		t.Errorf("expected different scopes to not share token")
+	}
+}
+
+func TestOAuth2TokenRefresh(t *testing.T) {
+	ts, count := tokenServer(t, 0, 100)
+	defer ts.Close()
+
+	tok := (&Service{}).oauth2Token(ts.URL, "client", "secret", nil)
+	token, err := tok.get(context.Background())
+	AssertNoError(t, err)
+	if token != "token-1" {
+		t.Fatalf("expected token-1, but got %q", token)
+	}
+	// Refreshed after 80% of the lifetime
+	if d := time.Until(tok.refreshAt); d <= 70*time.Second || d > 80*time.Second {
+		t.Errorf("expected refresh in about 80s, but got %s", d)
+	}
+
+	token, err = tok.get(context.Background())
+	AssertNoError(t, err)
+	if token != "token-1" || atomic.LoadInt32(count) != 1 {
+		t.Errorf("expected cached token-1, but got %q after %d requests", token, atomic.LoadInt32(count))
+	}
+
+	// The current token is returned while refreshing in the background
+	tok.mu.Lock()
+	tok.refreshAt = time.Now().Add(-time.Second)
+	tok.mu.Unlock()
+	token, err = tok.get(context.Background())
+	AssertNoError(t, err)
+	if token != "token-1" {
+		t.Errorf("expected token-1 while refreshing, but got %q", token)
+	}
+	waitRefreshed(t, tok)
+	token, err = tok.get(context.Background())
+	AssertNoError(t, err)
+	if token != "token-2" || atomic.LoadInt32(count) != 2 {
+		t.Errorf("expected new token-2, but got %q after %d requests", token, atomic.LoadInt32(count))
+	}
+}
+
+// waitRefreshed waits for any background refresh of the token to complete.
+func waitRefreshed(t *testing.T, tok *oauth2Token) {
+	for i := 0; i < 100; i++ {
+		tok.mu.Lock()
+		refreshing := tok.refreshing
+		tok.mu.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected token refresh to complete")
+}
+
+func TestOAuth2TokenRefreshFailure(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token-1","token_type":"Bearer","expires_in":100}`)
+	}))
+	defer ts.Close()
+
+	tok := (&Service{}).oauth2Token(ts.URL, "client", "secret", nil)
+	_, err := tok.get(context.Background())
+	AssertNoError(t, err)
+
+	// A failed refresh keeps the token until it expires
+	tok.mu.Lock()
+	tok.refreshAt = time.Now().Add(-time.Second)
+	tok.mu.Unlock()
+	token, err := tok.get(context.Background())
+	AssertNoError(t, err)
+	waitRefreshed(t, tok)
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("expected 2 token requests, but got %d", n)
+	}
+	token, err = tok.get(context.Background())
+	AssertNoError(t, err)
+	if token != "token-1" {
+		t.Errorf("expected token-1 after failed refresh, but got %q", token)
+	}
+	waitRefreshed(t, tok)
+
+	// An expired token is not used
+	tok.mu.Lock()
+	tok.expiresAt = time.Now().Add(-time.Second)
+	tok.mu.Unlock()
+	if token, err = tok.get(context.Background()); err == nil {
+		t.Errorf("expected an error for expired token, but got %q", token)
+	}
+}
+
+func TestOAuth2RetryOnUnauthorized(t *testing.T) {
+	tbl := []struct {
+		Accept   string // Accepted token, or empty if none
+		Expected int
+	}{
+		{"Bearer token-2", http.StatusOK},
+		{"", http.StatusUnauthorized},
+	}
+
+	for i, l := range tbl {
+		ts, count := tokenServer(t, 0, 3600)
+		var calls int32
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if l.Accept == "" || r.Header.Get("Authorization") != l.Accept {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+
+		s := &Service{}
+		ep := &endpoint{s: s, auth: &oauth2Auth{t: s.oauth2Token(ts.URL, "client", "secret", nil)}}
+		resp, err := ep.fetch(context.Background(), api.URL, nil)
+		AssertNoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != l.Expected {
+			t.Errorf("test %d: expected status %d, but got %d", i, l.Expected, resp.StatusCode)
+		}
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("test %d: expected 2 requests, but got %d", i, n)
+		}
+		if n := atomic.LoadInt32(count); n != 2 {
+			t.Errorf("test %d: expected 2 token requests, but got %d", i, n)
+		}
+		api.Close()
+		ts.Close()
+	}
+}
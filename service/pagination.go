@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type paginationType byte
+
+const (
+	paginationTypeLink paginationType = iota
+	paginationTypeCursor
+	paginationTypePage
+	paginationTypeOffset
+)
+
+// pagination holds the settings for following the pages of a paginated
+// endpoint.
+type pagination struct {
+	typ       paginationType
+	maxPages  int
+	itemsPath string
+	nextPath  string
+	param     string
+	start     int
+}
+
+func newPagination(c *PaginationCfg) (*pagination, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	p := &pagination{
+		maxPages:  c.MaxPages,
+		itemsPath: c.ItemsPath,
+		nextPath:  c.NextPath,
+		param:     c.Param,
+		start:     c.Start,
+	}
+	switch c.Type {
+	case "link":
+		p.typ = paginationTypeLink
+	case "cursor":
+		p.typ = paginationTypeCursor
+		if p.nextPath == "" {
+			return nil, errors.New("missing nextPath for cursor pagination")
+		}
+	case "page":
+		p.typ = paginationTypePage
+		if p.start == 0 {
+			p.start = 1
+		}
+	case "offset":
+		p.typ = paginationTypeOffset
+	default:
+		return nil, fmt.Errorf("invalid pagination type: %s", c.Type)
+	}
+	if p.param == "" && p.typ != paginationTypeLink {
+		return nil, fmt.Errorf("missing param for %s pagination", c.Type)
+	}
+	if p.maxPages <= 0 {
+		p.maxPages = 10
+	}
+	return p, nil
+}
+
+// items returns the items of a page. Items found at the items path are
+// taken as a list, as returned by value.list.
+func (p *pagination) items(v value) ([]value, error) {
+	if p.itemsPath != "" {
+		lv, ok := v.lookupList(p.itemsPath)
+		if !ok {
+			if _, found := v.lookup(p.itemsPath); !found {
+				return nil, fmt.Errorf("missing items at %s", p.itemsPath)
+			}
+			return nil, errors.New("page items is not an array")
+		}
+		v = lv
+	}
+	if v.typ != valueTypeArray {
+		return nil, errors.New("page items is not an array")
+	}
+	return v.arr, nil
+}
+
+// next returns the url of the next page, or an empty string if there are
+// no more pages. The url of the first page is firstURL, while pageURL,
+// v, and h are the url, value, and header of the last fetched page, and
+// count is the number of items fetched so far.
+func (p *pagination) next(firstURL, pageURL string, v value, h http.Header, page, count int) (string, error) {
+	switch p.typ {
+	case paginationTypeLink:
+		next := linkNext(h.Get("Link"))
+		if next == "" {
+			return "", nil
+		}
+		return resolveNextURL(firstURL, pageURL, next)
+	case paginationTypeCursor:
+		cv, ok := v.lookup(p.nextPath)
+		if !ok {
+			return "", nil
+		}
+		var cursor string
+		switch cv.typ {
+		case valueTypeString:
+			if err := json.Unmarshal(cv.raw, &cursor); err != nil {
+				return "", err
+			}
+		case valueTypeNumber:
+			cursor = string(cv.raw)
+		case valueTypeNull:
+		default:
+			return "", fmt.Errorf("invalid cursor value at %s", p.nextPath)
+		}
+		if cursor == "" {
+			return "", nil
+		}
+		if strings.HasPrefix(cursor, "/") || strings.HasPrefix(cursor, "http://") || strings.HasPrefix(cursor, "https://") {
+			return resolveNextURL(firstURL, pageURL, cursor)
+		}
+		return setQueryParam(firstURL, p.param, cursor)
+	case paginationTypePage:
+		return setQueryParam(firstURL, p.param, strconv.Itoa(p.start+page))
+	case paginationTypeOffset:
+		return setQueryParam(firstURL, p.param, strconv.Itoa(p.start+count))
+	}
+	return "", nil
+}
+
+// fetchPages follows the pages of a paginated endpoint, starting with the
+// already fetched first page, and returns the items of all pages
+// concatenated into a single array value. No more than the pagination's
+// max pages are fetched.
+func (ep *endpoint) fetchPages(ctx context.Context, firstURL string, v value, h http.Header, prev *cachedResponse, retries int) (value, error) {
+	p := ep.pagination
+	items, err := p.items(v)
+	if err != nil {
+		return value{}, err
+	}
+	all := items
+	pageURL := firstURL
+
+	for page := 1; len(items) > 0; page++ {
+		next, err := p.next(firstURL, pageURL, v, h, page, len(all))
+		if err != nil {
+			return value{}, err
+		}
+		if next == "" {
+			break
+		}
+		if page >= p.maxPages {
+			ep.s.Debugf("Max pages reached for %s", firstURL)
+			break
+		}
+
+		resp, err := ep.fetchRetry(ctx, next, prev, retries)
+		if err != nil {
+			return value{}, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			rerr := ep.statusError(resp)
+			resp.Body.Close()
+			return value{}, rerr
+		}
+		v, err = ep.readValue(resp)
+		resp.Body.Close()
+		if err != nil {
+			return value{}, err
+		}
+		if items, err = p.items(v); err != nil {
+			return value{}, err
+		}
+		all = append(all, items...)
+		h = resp.Header
+		pageURL = next
+	}
+
+	return value{typ: valueTypeArray, arr: all}, nil
+}
+
+// linkNext returns the target of the rel="next" link in a Link header, or
+// an empty string if not found.
+func linkNext(link string) string {
+	for _, l := range strings.Split(link, ",") {
+		parts := strings.Split(l, ";")
+		target := strings.TrimSpace(parts[0])
+		if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+			continue
+		}
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+				if strings.ToLower(rel) == "next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// resolveURL resolves a possibly relative reference against a base url.
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// resolveNextURL resolves the url of the next page against the url of the
+// last fetched page. As the endpoint's headers and auth are sent with each
+// page request, an error is returned if the next page is not on the same
+// scheme and host as the first page.
+func resolveNextURL(firstURL, pageURL, ref string) (string, error) {
+	next, err := resolveURL(pageURL, ref)
+	if err != nil {
+		return "", err
+	}
+	f, err := url.Parse(firstURL)
+	if err != nil {
+		return "", err
+	}
+	n, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(n.Scheme, f.Scheme) || !strings.EqualFold(n.Host, f.Host) {
+		return "", fmt.Errorf("next page url %s is not on the same host as %s", next, firstURL)
+	}
+	return next, nil
+}
+
+// setQueryParam returns the url with the query parameter set to the value.
+func setQueryParam(rawurl, param, v string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(param, v)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
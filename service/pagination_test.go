@@ -0,0 +1,77 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLinkNext(t *testing.T) {
+	tbl := []struct {
+		Link     string
+		Expected string
+	}{
+		{"", ""},
+		{`<https://api.example.com/items?page=2>; rel="next"`, "https://api.example.com/items?page=2"},
+		{`<https://api.example.com/items?page=1>; rel="prev", <https://api.example.com/items?page=3>; rel="next"`, "https://api.example.com/items?page=3"},
+		{`</items?page=2>; rel=next`, "/items?page=2"},
+		{`<https://api.example.com/items?page=9>; rel="last"`, ""},
+	}
+
+	for _, l := range tbl {
+		if next := linkNext(l.Link); next != l.Expected {
+			t.Errorf("expected link %q to have next %q, but got %q", l.Link, l.Expected, next)
+		}
+	}
+}
+
+func TestPaginationNext(t *testing.T) {
+	const first = "http://example.com/items?limit=2"
+	var page value
+	AssertNoError(t, json.Unmarshal([]byte(`{"items":[1,2],"meta":{"next":"abc"}}`), &page))
+
+	tbl := []struct {
+		Cfg      PaginationCfg
+		Expected string
+	}{
+		{PaginationCfg{Type: "link"}, "http://example.com/items?limit=2&page=2"},
+		{PaginationCfg{Type: "cursor", NextPath: "meta.next", Param: "cursor"}, "http://example.com/items?cursor=abc&limit=2"},
+		{PaginationCfg{Type: "page", Param: "page"}, "http://example.com/items?limit=2&page=2"},
+		{PaginationCfg{Type: "offset", Param: "offset"}, "http://example.com/items?limit=2&offset=2"},
+	}
+
+	h := http.Header{"Link": {`</items?limit=2&page=2>; rel="next"`}}
+	for _, l := range tbl {
+		p, err := newPagination(&l.Cfg)
+		AssertNoError(t, err)
+		next, err := p.next(first, first, page, h, 1, 2)
+		AssertNoError(t, err)
+		if next != l.Expected {
+			t.Errorf("expected %s pagination to have next %q, but got %q", l.Cfg.Type, l.Expected, next)
+		}
+	}
+}
+
+func TestPaginationNextOtherHost(t *testing.T) {
+	const first = "https://example.com/items"
+	var page value
+	AssertNoError(t, json.Unmarshal([]byte(`{"next":"https://other.example.com/items?cursor=abc"}`), &page))
+
+	tbl := []struct {
+		Cfg PaginationCfg
+		H   http.Header
+	}{
+		{PaginationCfg{Type: "link"}, http.Header{"Link": {`<https://other.example.com/items?page=2>; rel="next"`}}},
+		{PaginationCfg{Type: "link"}, http.Header{"Link": {`<http://example.com/items?page=2>; rel="next"`}}},
+		{PaginationCfg{Type: "link"}, http.Header{"Link": {`<//other.example.com/items?page=2>; rel="next"`}}},
+		{PaginationCfg{Type: "cursor", NextPath: "next", Param: "cursor"}, http.Header{}},
+	}
+
+	for i, l := range tbl {
+		p, err := newPagination(&l.Cfg)
+		AssertNoError(t, err)
+		if next, err := p.next(first, first, page, l.H, 1, 2); err == nil {
+			t.Errorf("test %d: expected an error, but got next %q", i, next)
+		}
+	}
+}
@@ -18,13 +18,22 @@ var errInvalidPattern = errors.New("invalid pattern")
 // to the next nodes.
 // Only one instance of handlers may exist per node.
 type node struct {
-	typ     resourceType
-	nodes   map[string]*node
-	param   *node
-	pattern string
-	params  []patternParam // pattern parameters
-	ptyp    pathType
-	idProp  string
+	typ      resourceType
+	nodes    map[string]*node
+	param    *node
+	pattern  string
+	params   []patternParam // pattern parameters
+	ptyp     pathType
+	idProp   string
+	proj     *projection // nil if all properties are kept as is
+	unmapped unmappedMode
+	// Collection diff settings
+	diff         diffMode
+	key          string
+	reorderReset int
+	// Model change settings
+	ignore    map[string]bool
+	tolerance float64
 }
 
 // A pattern represent a parameter part of the resource name pattern.
@@ -168,6 +177,25 @@ func (rn *node) addPath(path string, pattern string, urlParams []string, typStr
 	return nil
 }
 
+// nodeAt returns the node registered for the path, or nil if not found.
+func (rn *node) nodeAt(path string) *node {
+	if path == "" {
+		return rn
+	}
+	l := rn
+	for _, t := range strings.Split(path, btsep) {
+		if t != "" && t[0] == pmark {
+			l = l.param
+		} else {
+			l = l.nodes[t]
+		}
+		if l == nil {
+			return nil
+		}
+	}
+	return l
+}
+
 func parsePattern(pattern string) (string, []patternParam, error) {
 	var tokens []string
 	if pattern != "" {
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A projection selects and renames the properties of a model resource.
+type projection struct {
+	include map[string]bool
+	exclude map[string]bool
+	rename  map[string]string
+}
+
+// newProjection creates a projection from the resource config, or returns
+// nil if the resource has no include, exclude, or rename settings.
+func newProjection(r ResourceCfg) (*projection, error) {
+	if r.Include == nil && len(r.Exclude) == 0 && len(r.Rename) == 0 {
+		return nil, nil
+	}
+	if r.Type != "model" {
+		return nil, errors.New("include, exclude, and rename must only be used on model resources")
+	}
+	if r.Include != nil && len(r.Exclude) > 0 {
+		return nil, errors.New("include and exclude must not both be set")
+	}
+
+	p := &projection{}
+	if r.Include != nil {
+		p.include = make(map[string]bool, len(r.Include))
+		for _, k := range r.Include {
+			p.include[k] = true
+		}
+	}
+	if len(r.Exclude) > 0 {
+		p.exclude = make(map[string]bool, len(r.Exclude))
+		for _, k := range r.Exclude {
+			p.exclude[k] = true
+		}
+	}
+	if len(r.Rename) > 0 {
+		p.rename = make(map[string]string, len(r.Rename))
+		names := make(map[string]string, len(r.Rename))
+		for k, name := range r.Rename {
+			if name == "" {
+				return nil, fmt.Errorf("missing new name for property %s", k)
+			}
+			if prev, ok := names[name]; ok {
+				return nil, fmt.Errorf("properties %s and %s both renamed to %s", prev, k, name)
+			}
+			names[name] = k
+			p.rename[k] = name
+		}
+	}
+	return p, nil
+}
+
+// name returns the model property name for an upstream property key. The
+// bool is false if the property should be omitted.
+func (p *projection) name(k string) (string, bool) {
+	if p == nil {
+		return k, true
+	}
+	if p.include != nil && !p.include[k] {
+		return "", false
+	}
+	if p.exclude[k] {
+		return "", false
+	}
+	if name, ok := p.rename[k]; ok {
+		return name, true
+	}
+	return k, true
+}
+
+// collision returns an error if a property of obj is renamed to the name of
+// another property of obj that is kept as is, as only one of them can be
+// set on the model.
+func (p *projection) collision(obj map[string]value) error {
+	if p == nil || len(p.rename) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(p.rename))
+	for k := range p.rename {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := obj[k]; !ok {
+			continue
+		}
+		name, ok := p.name(k)
+		if !ok {
+			continue
+		}
+		if _, ok := obj[name]; !ok {
+			continue
+		}
+		if other, ok := p.name(name); ok && other == name {
+			return fmt.Errorf("property %s renamed to %s collides with property %s", k, name, name)
+		}
+	}
+	return nil
+}
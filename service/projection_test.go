@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProjectionName(t *testing.T) {
+	tbl := []struct {
+		Cfg      ResourceCfg
+		Key      string
+		Expected string
+		OK       bool
+	}{
+		{ResourceCfg{Type: "model"}, "id", "id", true},
+		{ResourceCfg{Type: "model", Include: []string{"id"}}, "id", "id", true},
+		{ResourceCfg{Type: "model", Include: []string{"id"}}, "name", "", false},
+		{ResourceCfg{Type: "model", Include: []string{}}, "id", "", false},
+		{ResourceCfg{Type: "model", Exclude: []string{"secret"}}, "secret", "", false},
+		{ResourceCfg{Type: "model", Exclude: []string{"secret"}}, "id", "id", true},
+		{ResourceCfg{Type: "model", Rename: map[string]string{"stn_nm": "name"}}, "stn_nm", "name", true},
+		{ResourceCfg{Type: "model", Include: []string{"stn_nm"}, Rename: map[string]string{"stn_nm": "name"}}, "stn_nm", "name", true},
+	}
+
+	for i, l := range tbl {
+		p, err := newProjection(l.Cfg)
+		AssertNoError(t, err)
+		name, ok := p.name(l.Key)
+		if name != l.Expected || ok != l.OK {
+			t.Errorf("test %d: expected %#v, %v, but got %#v, %v", i, l.Expected, l.OK, name, ok)
+		}
+	}
+}
+
+func TestNewProjectionErrors(t *testing.T) {
+	tbl := []ResourceCfg{
+		{Type: "collection", Exclude: []string{"id"}},
+		{Type: "model", Include: []string{"id"}, Exclude: []string{"name"}},
+		{Type: "model", Rename: map[string]string{"a": ""}},
+		{Type: "model", Rename: map[string]string{"a": "c", "b": "c"}},
+	}
+
+	for i, l := range tbl {
+		if _, err := newProjection(l); err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+}
+
+func TestTraverseModelWithProjection(t *testing.T) {
+	root := &node{}
+	AssertNoError(t, root.addPath("", "station", nil, "model", ""))
+	AssertNoError(t, root.addPath("info", "station.info", nil, "model", ""))
+	p, err := newProjection(ResourceCfg{Type: "model", Exclude: []string{"internal"}, Rename: map[string]string{"stn_nm": "name", "info": "details"}})
+	AssertNoError(t, err)
+	root.nodeAt("").proj = p
+
+	var v value
+	AssertNoError(t, json.Unmarshal([]byte(`{"stn_nm":"Central","internal":42,"info":{"open":true}}`), &v))
+	crs := make(map[string]cachedResource)
+	_, err = traverseModel(crs, v, nil, root, nil, "")
+	AssertNoError(t, err)
+
+	model := crs["station"].model
+	if len(model) != 2 {
+		t.Fatalf("expected 2 properties, but got %d", len(model))
+	}
+	if _, ok := model["name"]; !ok {
+		t.Errorf("expected property name to be set")
+	}
+	if _, ok := model["details"]; !ok {
+		t.Errorf("expected property details to be set")
+	}
+	if _, ok := crs["station.info"]; !ok {
+		t.Errorf("expected resource station.info to be set")
+	}
+}
+
+func TestTraverseModelWithRenameCollision(t *testing.T) {
+	tbl := []struct {
+		Cfg  ResourceCfg
+		Body string
+		OK   bool
+	}{
+		{ResourceCfg{Type: "model", Rename: map[string]string{"a": "b"}}, `{"a":1}`, true},
+		{ResourceCfg{Type: "model", Rename: map[string]string{"a": "b"}}, `{"a":1,"b":2}`, false},
+		{ResourceCfg{Type: "model", Rename: map[string]string{"a": "b"}, Exclude: []string{"b"}}, `{"a":1,"b":2}`, true},
+		{ResourceCfg{Type: "model", Rename: map[string]string{"a": "b"}, Exclude: []string{"a"}}, `{"a":1,"b":2}`, true},
+		{ResourceCfg{Type: "model", Rename: map[string]string{"a": "b", "b": "c"}}, `{"a":1,"b":2}`, true},
+	}
+
+	for i, l := range tbl {
+		root := &node{}
+		AssertNoError(t, root.addPath("", "station", nil, "model", ""))
+		p, err := newProjection(l.Cfg)
+		AssertNoError(t, err)
+		root.nodeAt("").proj = p
+
+		var v value
+		AssertNoError(t, json.Unmarshal([]byte(l.Body), &v))
+		_, err = traverseModel(make(map[string]cachedResource), v, nil, root, nil, "")
+		if l.OK && err != nil {
+			t.Errorf("test %d: expected no error, but got %s", i, err)
+		} else if !l.OK && err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type priority byte
+
+const (
+	// priorityHigh is used for fetches requested by clients.
+	priorityHigh priority = iota
+	// priorityLow is used for background polling.
+	priorityLow
+	priorityCount
+)
+
+// A rateLimitPool is a named rate limit shared by all endpoints using it.
+// If perHost is set, each upstream host has a separate token bucket.
+type rateLimitPool struct {
+	name     string
+	interval time.Duration // Duration for a token to be added
+	burst    int
+	perHost  bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// A tokenBucket is a token bucket rate limiter where waiting requests are
+// served in priority order, and in order of arrival within each priority.
+type tokenBucket struct {
+	interval time.Duration
+	burst    float64
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	waiters [priorityCount][]chan struct{}
+	timer   *time.Timer
+}
+
+// newRateLimitPools creates the rate limit pools from the config. Errors
+// are returned as a *ConfigError.
+func newRateLimitPools(cfg map[string]RateLimitCfg) (map[string]*rateLimitPool, error) {
+	pools := make(map[string]*rateLimitPool, len(cfg))
+	for name, c := range cfg {
+		ptr := "/rateLimits/" + pointerToken(name)
+		if c.Requests <= 0 {
+			return nil, &ConfigError{Pointer: ptr + "/requests", Message: fmt.Sprintf("rate limit %s must have requests greater than 0", name)}
+		}
+		if c.Interval <= 0 {
+			return nil, &ConfigError{Pointer: ptr + "/interval", Message: fmt.Sprintf("rate limit %s must have interval greater than 0", name)}
+		}
+		burst := c.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		pools[name] = &rateLimitPool{
+			name:     name,
+			interval: time.Millisecond * time.Duration(c.Interval) / time.Duration(c.Requests),
+			burst:    burst,
+			perHost:  c.PerHost,
+			buckets:  make(map[string]*tokenBucket),
+		}
+	}
+	return pools, nil
+}
+
+// bucket returns the token bucket to use for requests to the host.
+func (p *rateLimitPool) bucket(host string) *tokenBucket {
+	if !p.perHost {
+		host = ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.buckets[host]
+	if !ok {
+		b = &tokenBucket{
+			interval: p.interval,
+			burst:    float64(p.burst),
+			tokens:   float64(p.burst),
+			last:     time.Now(),
+		}
+		p.buckets[host] = b
+	}
+	return b
+}
+
+// wait blocks until a token is available and taken, or until the context
+// is done, in which case the context error is returned.
+func (b *tokenBucket) wait(ctx context.Context, prio priority) error {
+	b.mu.Lock()
+	b.refill(time.Now())
+	if b.tokens >= 1 && b.waiting() == 0 {
+		b.tokens--
+		b.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	b.waiters[prio] = append(b.waiters[prio], ch)
+	b.schedule()
+	b.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if !b.remove(prio, ch) {
+			// The token was granted while canceling
+			return nil
+		}
+		return ctx.Err()
+	}
+}
+
+// refill adds the tokens accumulated since last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// waiting returns the number of waiting requests.
+func (b *tokenBucket) waiting() int {
+	n := 0
+	for _, w := range b.waiters {
+		n += len(w)
+	}
+	return n
+}
+
+// remove removes a waiter, returning false if it was not found.
+func (b *tokenBucket) remove(prio priority, ch chan struct{}) bool {
+	w := b.waiters[prio]
+	for i, c := range w {
+		if c == ch {
+			b.waiters[prio] = append(w[:i], w[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// schedule sets a timer to dispatch tokens to waiters once the next token
+// is available, unless a timer is already set.
+func (b *tokenBucket) schedule() {
+	if b.timer != nil {
+		return
+	}
+	d := time.Duration((1 - b.tokens) * float64(b.interval))
+	b.timer = time.AfterFunc(d, b.dispatch)
+}
+
+// dispatch grants available tokens to waiters in priority order.
+func (b *tokenBucket) dispatch() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timer = nil
+	b.refill(time.Now())
+	for p := range b.waiters {
+		for len(b.waiters[p]) > 0 && b.tokens >= 1 {
+			b.tokens--
+			close(b.waiters[p][0])
+			b.waiters[p] = b.waiters[p][1:]
+		}
+	}
+	if b.waiting() > 0 {
+		b.schedule()
+	}
+}
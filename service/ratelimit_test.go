@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketPriority(t *testing.T) {
+	pools, err := newRateLimitPools(map[string]RateLimitCfg{
+		"test": {Requests: 1, Interval: 20},
+	})
+	AssertNoError(t, err)
+	b := pools["test"].bucket("example.com")
+
+	// Take the burst token
+	AssertNoError(t, b.wait(context.Background(), priorityLow))
+
+	order := make(chan priority, 2)
+	waitFor := func(n int) {
+		for {
+			b.mu.Lock()
+			w := b.waiting()
+			b.mu.Unlock()
+			if w == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, prio := range []priority{priorityLow, priorityHigh} {
+		go func(prio priority) {
+			if err := b.wait(context.Background(), prio); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			order <- prio
+		}(prio)
+		waitFor(i + 1)
+	}
+
+	if prio := <-order; prio != priorityHigh {
+		t.Errorf("expected high priority waiter to be served first")
+	}
+	if prio := <-order; prio != priorityLow {
+		t.Errorf("expected low priority waiter to be served last")
+	}
+}
+
+func TestTokenBucketCanceled(t *testing.T) {
+	pools, err := newRateLimitPools(map[string]RateLimitCfg{
+		"test": {Requests: 1, Interval: 60000},
+	})
+	AssertNoError(t, err)
+	b := pools["test"].bucket("example.com")
+	AssertNoError(t, b.wait(context.Background(), priorityHigh))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx, priorityHigh); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded error, but got %v", err)
+	}
+	if w := b.waiting(); w != 0 {
+		t.Errorf("expected no waiters, but got %d", w)
+	}
+}
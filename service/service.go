@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	res "github.com/jirenius/go-res"
 	"github.com/jirenius/resgate/logger"
@@ -12,10 +14,19 @@ import (
 // A Service handles incoming requests from NATS Server and calls the
 // appropriate callback on the resource handlers.
 type Service struct {
-	res    *res.Service
-	nc     res.Conn      // NATS Server connection
-	logger logger.Logger // Logger
-	cfg    Config
+	res        *res.Service
+	nc         res.Conn      // NATS Server connection
+	logger     logger.Logger // Logger
+	cfg        Config
+	ctx        context.Context
+	cancel     context.CancelFunc
+	tokens     map[string]*oauth2Token
+	rateLimits map[string]*rateLimitPool
+	upstreams  map[string]*upstream
+	endpoints  []*endpoint
+	// Registered resources
+	registrations []Registration
+	mu            sync.Mutex
 }
 
 // NewService creates a new rest2res service.
@@ -25,6 +36,12 @@ func NewService(cfg Config) (*Service, error) {
 		cfg:    cfg,
 		logger: logger.NewStdLogger(false, false),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	rl, err := newRateLimitPools(cfg.RateLimits)
+	if err != nil {
+		return nil, ConfigErrors{err.(*ConfigError)}
+	}
+	s.rateLimits = rl
 	if err := s.addResources(); err != nil {
 		return nil, err
 	}
@@ -94,30 +111,66 @@ func (s *Service) Serve(nc res.Conn) error {
 	return s.res.Serve(nc)
 }
 
-// Shutdown closes any existing connection to NATS Server.
+// Shutdown cancels any in-flight requests to the legacy endpoints, and
+// closes any existing connection to NATS Server.
 // Returns an error if service is not started.
 func (s *Service) Shutdown() error {
+	s.cancel()
 	return s.res.Shutdown()
 }
 
+// stopped reports whether the service has been shut down.
+func (s *Service) stopped() bool {
+	return s.ctx != nil && s.ctx.Err() != nil
+}
+
+// addResources adds the resources of all endpoints. Errors are returned as
+// ConfigErrors, containing the first error found for each invalid endpoint.
 func (s *Service) addResources() error {
+	var errs ConfigErrors
 	for i := range s.cfg.Endpoints {
 		cep := &s.cfg.Endpoints[i]
+		ptr := fmt.Sprintf("/endpoints/%d", i)
 
 		ep, err := newEndpoint(s, cep)
 		if err != nil {
-			return fmt.Errorf("endpoint #%d is invalid: %s", i+1, err)
+			errs = append(errs, &ConfigError{Pointer: ptr, Message: err.Error()})
+			continue
+		}
+		s.endpoints = append(s.endpoints, ep)
+		err = s.addResource(ep, cep.ResourceCfg, "", "", s.cfg.Unmapped, ptr)
+		if err == nil && cep.EnvelopePattern != "" {
+			err = s.addEnvelope(ep, cep.EnvelopePattern, ptr+"/envelopePattern")
 		}
-		err = s.addResource(ep, cep.ResourceCfg, "", "")
 		if err != nil {
-			return fmt.Errorf("endpoint #%d has invalid config: %s", i+1, err)
+			errs = append(errs, err.(*ConfigError))
 		}
 	}
 
+	if errs != nil {
+		return errs
+	}
 	return nil
 }
 
-func (s *Service) addResource(ep *endpoint, r ResourceCfg, pattern, path string) error {
+// addEnvelope adds the model resource for the envelope metadata of an
+// endpoint.
+func (s *Service) addEnvelope(ep *endpoint, pattern, ptr string) error {
+	rid := s.cfg.ServiceName + "." + pattern
+
+	ep.envelope = &node{}
+	if err := ep.envelope.addPath("", rid, ep.urlParams, "model", ""); err != nil {
+		return configError(ptr, err)
+	}
+
+	return s.addHandler(ep, pattern, rid, "model", ptr)
+}
+
+// addResource adds the resource and its child resources to the endpoint.
+// The unmapped setting is inherited from the parent unless set on the
+// resource. Errors are returned as a *ConfigError, located by the JSON
+// pointer of the resource config.
+func (s *Service) addResource(ep *endpoint, r ResourceCfg, pattern, path, unmapped, ptr string) error {
 	if r.Pattern != "" {
 		pattern = r.Pattern
 	} else if r.Path != "" {
@@ -128,7 +181,7 @@ func (s *Service) addResource(ep *endpoint, r ResourceCfg, pattern, path string)
 		if path == "" {
 			path = r.Path
 		} else {
-			path = "." + r.Path
+			path += "." + r.Path
 		}
 	}
 
@@ -138,19 +191,59 @@ func (s *Service) addResource(ep *endpoint, r ResourceCfg, pattern, path string)
 	}
 
 	if err := ep.addPath(path, rid, ep.urlParams, r.Type, r.IDProp); err != nil {
-		return err
+		return configError(ptr, err)
+	}
+	proj, err := newProjection(r)
+	if err != nil {
+		return configError(ptr, err)
+	}
+	if r.Unmapped != "" {
+		unmapped = r.Unmapped
+	}
+	um, err := parseUnmappedMode(unmapped)
+	if err != nil {
+		return configError(ptr, err)
+	}
+	n := ep.nodeAt(path)
+	n.proj = proj
+	n.unmapped = um
+	if err := n.setChanges(r); err != nil {
+		return configError(ptr, err)
 	}
 
-	ep.resetPatterns = append(ep.resetPatterns, resetPattern(rid, ep.urlParams))
-	s.res.AddHandler(pattern, ep.handler())
+	if err := s.addHandler(ep, pattern, rid, r.Type, ptr); err != nil {
+		return err
+	}
 
 	// Recursively add child resources
-	for _, nr := range r.Resources {
-		if err := s.addResource(ep, nr, pattern, path); err != nil {
+	for i, nr := range r.Resources {
+		if err := s.addResource(ep, nr, pattern, path, unmapped, fmt.Sprintf("%s/resources/%d", ptr, i)); err != nil {
 			return err
 		}
 	}
 
+	// Set diff settings once the child resources are added
+	if err := n.setDiff(r); err != nil {
+		return configError(ptr, err)
+	}
+
+	return nil
+}
+
+// addHandler adds the endpoint handler for the resource pattern, and
+// registers the resource. Returns a *ConfigError if the pattern collides
+// with an already registered pattern.
+func (s *Service) addHandler(ep *endpoint, pattern, rid, typ, ptr string) error {
+	np := normalizePattern(rid)
+	for _, reg := range s.registrations {
+		if normalizePattern(reg.Pattern) == np {
+			return &ConfigError{Pointer: ptr, Message: fmt.Sprintf("pattern %s collides with pattern %s at %s", rid, reg.Pattern, reg.Pointer)}
+		}
+	}
+	s.registrations = append(s.registrations, Registration{Pattern: rid, Type: typ, Pointer: ptr})
+
+	ep.resetPatterns = append(ep.resetPatterns, resetPattern(rid, ep.urlParams))
+	s.res.AddHandler(pattern, ep.handler())
 	return nil
 }
 
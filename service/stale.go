@@ -0,0 +1,138 @@
+package service
+
+import (
+	"time"
+
+	res "github.com/jirenius/go-res"
+)
+
+// isStale reports whether the cached response failed to refresh, and is
+// still within the endpoint's staleIfError window.
+func (ep *endpoint) isStale(cresp *cachedResponse) bool {
+	return ep.staleIfError > 0 &&
+		!cresp.staleSince.IsZero() &&
+		time.Since(cresp.fetched) < ep.staleIfError
+}
+
+// staleExpired reports whether the cached response failed to refresh, and
+// the endpoint's staleIfError window has passed, so that the stale data must
+// no longer be served.
+func (ep *endpoint) staleExpired(cresp *cachedResponse) bool {
+	return ep.staleIfError > 0 &&
+		!cresp.staleSince.IsZero() &&
+		time.Since(cresp.fetched) >= ep.staleIfError
+}
+
+// markStale flags the cached response as stale, unless it already is.
+// If the endpoint has a stale property, it is set on the root model to the
+// time, in milliseconds since epoch, when the response became stale. If
+// sendEvent is true, a change event is sent for the root model.
+func (ep *endpoint) markStale(cresp *cachedResponse, sendEvent bool) {
+	if !cresp.staleSince.IsZero() {
+		return
+	}
+	cresp.staleSince = time.Now()
+	ep.s.Logf("Serving stale data for %s", cresp.root)
+
+	if ep.staleProp == "" {
+		return
+	}
+	v := cresp.staleSince.UnixNano() / int64(time.Millisecond)
+	ep.setRootProp(cresp, v, sendEvent)
+}
+
+// clearStale removes the stale flag from a cached response that has been
+// successfully refreshed without any change, sending a change event
+// to remove the stale property if one is set.
+func (ep *endpoint) clearStale(cresp *cachedResponse) {
+	if cresp.staleSince.IsZero() {
+		return
+	}
+	cresp.staleSince = time.Time{}
+	ep.s.Logf("Stale data refreshed for %s", cresp.root)
+
+	if ep.staleProp == "" {
+		return
+	}
+	ep.setRootProp(cresp, res.DeleteAction, true)
+}
+
+// setRootProp sets a property on the cached root model, or deletes it if v
+// is res.DeleteAction.
+func (ep *endpoint) setRootProp(cresp *cachedResponse, v interface{}, sendEvent bool) {
+	cr, ok := cresp.crs[cresp.root]
+	if !ok || cr.typ != resourceTypeModel {
+		return
+	}
+
+	// Copy the model as it may be in use
+	model := make(map[string]interface{}, len(cr.model)+1)
+	for k, mv := range cr.model {
+		model[k] = mv
+	}
+	if v == res.DeleteAction {
+		delete(model, ep.staleProp)
+	} else {
+		model[ep.staleProp] = v
+	}
+	cr.model = model
+	// The hash no longer matches the content
+	cr.hash = resourceHash{}
+	cresp.crs[cresp.root] = cr
+
+	if sendEvent {
+		r, err := ep.s.res.Resource(cresp.root)
+		if err != nil {
+			ep.s.Logf("Error getting res resource %s:\n\t%s", cresp.root, err)
+			return
+		}
+		r.ChangeEvent(map[string]interface{}{ep.staleProp: v})
+	}
+}
+
+// keepStale stores a cached response that is being reset, so that it may be
+// served if fetching the url again fails within the staleIfError window.
+// The entry is removed once the window has passed.
+// Must be called with the endpoint lock held.
+func (ep *endpoint) keepStale(url string, cresp *cachedResponse) {
+	if ep.staleIfError == 0 || cresp.rerr != nil {
+		return
+	}
+	remaining := ep.staleIfError - time.Since(cresp.fetched)
+	if remaining <= 0 {
+		return
+	}
+	ep.staleURLs[url] = cresp
+	time.AfterFunc(remaining, func() {
+		ep.mu.Lock()
+		if ep.staleURLs[url] == cresp {
+			delete(ep.staleURLs, url)
+		}
+		ep.mu.Unlock()
+	})
+}
+
+// staleFallback returns a previously reset response for the url to serve
+// instead of the error, or nil if none is available. A not found error is
+// never replaced.
+// Must be called with the endpoint lock held.
+func (ep *endpoint) staleFallback(url string, rerr *res.Error) *cachedResponse {
+	if rerr.Code == res.CodeNotFound {
+		return nil
+	}
+	cresp, ok := ep.staleURLs[url]
+	if !ok {
+		return nil
+	}
+	delete(ep.staleURLs, url)
+	if time.Since(cresp.fetched) >= ep.staleIfError {
+		return nil
+	}
+	ep.s.Logf("Error fetching url %s:\n\t%s", url, rerr.Message)
+	cresp.reloads = 0
+	cresp.mu.Lock()
+	cresp.reset = false
+	ep.markStale(cresp, false)
+	cresp.mu.Unlock()
+	return cresp
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleWindow(t *testing.T) {
+	now := time.Now()
+	tbl := []struct {
+		StaleIfError time.Duration
+		Fetched      time.Time
+		StaleSince   time.Time
+		Stale        bool
+		Expired      bool
+	}{
+		{0, now.Add(-time.Second), now, false, false},
+		{time.Minute, now.Add(-time.Second), time.Time{}, false, false},
+		{time.Minute, now.Add(-time.Second), now, true, false},
+		{time.Minute, now.Add(-2 * time.Minute), now, false, true},
+	}
+
+	for i, l := range tbl {
+		ep := &endpoint{staleIfError: l.StaleIfError}
+		cresp := &cachedResponse{fetched: l.Fetched, staleSince: l.StaleSince}
+		if stale := ep.isStale(cresp); stale != l.Stale {
+			t.Errorf("test %d: expected isStale to be %v, but got %v", i, l.Stale, stale)
+		}
+		if expired := ep.staleExpired(cresp); expired != l.Expired {
+			t.Errorf("test %d: expected staleExpired to be %v, but got %v", i, l.Expired, expired)
+		}
+	}
+}
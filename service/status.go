@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	res "github.com/jirenius/go-res"
+)
+
+// maxErrorBodySize is the maximum number of bytes read from an error
+// response body when looking for an error message.
+const maxErrorBodySize = 64 * 1024
+
+// A statusMap maps upstream HTTP status codes to RES error codes.
+type statusMap struct {
+	codes   map[int]string
+	classes map[int]string // Keyed by status code class, eg. 4 for 4XX
+}
+
+// newStatusMap creates a status map from the config, where keys are
+// either status codes, eg. "404", or status classes, eg. "5XX".
+// A 404 status code maps to system.notFound, and 429 maps to
+// system.rateLimited, unless overridden.
+func newStatusMap(m map[string]string) (statusMap, error) {
+	sm := statusMap{
+		codes: map[int]string{
+			http.StatusNotFound:        res.CodeNotFound,
+			http.StatusTooManyRequests: CodeRateLimited,
+		},
+		classes: make(map[int]string),
+	}
+	for k, code := range m {
+		if code == "" {
+			return sm, fmt.Errorf("missing error code for status %s", k)
+		}
+		if len(k) == 3 && strings.ToUpper(k[1:]) == "XX" && k[0] >= '1' && k[0] <= '5' {
+			sm.classes[int(k[0]-'0')] = code
+			continue
+		}
+		status, err := strconv.Atoi(k)
+		if err != nil || status < 100 || status > 599 {
+			return sm, fmt.Errorf("invalid status code: %s", k)
+		}
+		sm.codes[status] = code
+	}
+	return sm, nil
+}
+
+// code returns the RES error code for the status code, or an empty
+// string if the status is not mapped.
+func (sm statusMap) code(status int) string {
+	if code, ok := sm.codes[status]; ok {
+		return code
+	}
+	return sm.classes[status/100]
+}
+
+// statusError returns the RES error for a non-2XX response. Unmapped status
+// codes result in an internal error. If the endpoint has an error message
+// property, the message is taken from the response body when available.
+func (ep *endpoint) statusError(resp *http.Response) *res.Error {
+	code := ep.statusMap.code(resp.StatusCode)
+	if code == "" {
+		return res.InternalError(fmt.Errorf("unexpected response code: %d", resp.StatusCode))
+	}
+
+	msg := ""
+	if ep.errorMessageProp != "" {
+		msg = errorMessage(resp.Body, ep.errorMessageProp)
+	}
+	if msg == "" {
+		msg = defaultErrorMessage(code, resp.StatusCode)
+	}
+	return &res.Error{Code: code, Message: msg}
+}
+
+// errorMessage reads a JSON body and returns the string value found at the
+// dot-separated property path, or an empty string if not found.
+func errorMessage(r io.Reader, prop string) string {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxErrorBodySize))
+	if err != nil {
+		return ""
+	}
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return ""
+	}
+	for _, k := range strings.Split(prop, btsep) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = obj[k]
+	}
+	msg, _ := v.(string)
+	return msg
+}
+
+// defaultErrorMessage returns the message to use for an error code when no
+// message is provided by the upstream.
+func defaultErrorMessage(code string, status int) string {
+	switch code {
+	case res.CodeNotFound:
+		return "Not found"
+	case res.CodeAccessDenied:
+		return "Access denied"
+	case res.CodeInvalidParams:
+		return "Invalid parameters"
+	case res.CodeTimeout:
+		return "Request timeout"
+	case CodeRateLimited:
+		return "Rate limited by upstream"
+	}
+	return fmt.Sprintf("Upstream responded with %d %s", status, http.StatusText(status))
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	res "github.com/jirenius/go-res"
+)
+
+func TestStatusMapCode(t *testing.T) {
+	sm, err := newStatusMap(map[string]string{
+		"400": "system.invalidParams",
+		"5XX": "system.unavailable",
+		"4xx": "system.accessDenied",
+		"503": "custom.maintenance",
+	})
+	AssertNoError(t, err)
+
+	tbl := []struct {
+		Status   int
+		Expected string
+	}{
+		{http.StatusBadRequest, "system.invalidParams"},
+		{http.StatusNotFound, res.CodeNotFound},
+		{http.StatusTooManyRequests, CodeRateLimited},
+		{http.StatusForbidden, "system.accessDenied"},
+		{http.StatusInternalServerError, "system.unavailable"},
+		{http.StatusServiceUnavailable, "custom.maintenance"},
+		{http.StatusMovedPermanently, ""},
+	}
+
+	for _, l := range tbl {
+		if code := sm.code(l.Status); code != l.Expected {
+			t.Errorf("expected status %d to map to %q, but got %q", l.Status, l.Expected, code)
+		}
+	}
+}
+
+func TestStatusMapDefaults(t *testing.T) {
+	sm, err := newStatusMap(map[string]string{"404": "custom.gone", "429": "system.unavailable"})
+	AssertNoError(t, err)
+	if code := sm.code(http.StatusNotFound); code != "custom.gone" {
+		t.Errorf("expected overridden 404 to map to custom.gone, but got %q", code)
+	}
+	if code := sm.code(http.StatusTooManyRequests); code != "system.unavailable" {
+		t.Errorf("expected overridden 429 to map to system.unavailable, but got %q", code)
+	}
+	if code := sm.code(http.StatusInternalServerError); code != "" {
+		t.Errorf("expected unmapped 500, but got %q", code)
+	}
+}
+
+func TestNewStatusMapErrors(t *testing.T) {
+	tbl := []map[string]string{
+		{"404": ""},
+		{"foo": "system.notFound"},
+		{"99": "system.notFound"},
+		{"600": "system.notFound"},
+		{"6XX": "system.notFound"},
+		{"5X": "system.notFound"},
+		{"5XXX": "system.notFound"},
+	}
+
+	for i, l := range tbl {
+		if _, err := newStatusMap(l); err == nil {
+			t.Errorf("test %d: expected an error, but got none", i)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tbl := []struct {
+		Body     string
+		Prop     string
+		Expected string
+	}{
+		{`{"message":"Not here"}`, "message", "Not here"},
+		{`{"error":{"detail":"Bad id"}}`, "error.detail", "Bad id"},
+		{`{"error":{"detail":42}}`, "error.detail", ""},
+		{`{"error":"Bad id"}`, "error.detail", ""},
+		{`{"message":"Not here"}`, "missing", ""},
+		{`<html>Not found</html>`, "message", ""},
+		{``, "message", ""},
+	}
+
+	for _, l := range tbl {
+		if msg := errorMessage(strings.NewReader(l.Body), l.Prop); msg != l.Expected {
+			t.Errorf("expected message of %s at %q to be %q, but got %q", l.Body, l.Prop, l.Expected, msg)
+		}
+	}
+}
+
+func TestErrorMessageLimit(t *testing.T) {
+	body := `{"message":"` + strings.Repeat("x", maxErrorBodySize) + `"}`
+	if msg := errorMessage(strings.NewReader(body), "message"); msg != "" {
+		t.Errorf("expected no message for a body exceeding the size limit, but got %d bytes", len(msg))
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	res "github.com/jirenius/go-res"
+)
+
+// RES error codes for upstream errors.
+const (
+	// CodeUnavailable is returned when an upstream host is unavailable.
+	CodeUnavailable = "system.unavailable"
+	// CodeRateLimited is returned when an upstream host rate limits requests.
+	CodeRateLimited = "system.rateLimited"
+)
+
+// defaultRateLimitBackoff is the duration to back off from an upstream host
+// responding with 429 Too Many Requests, without telling for how long.
+const defaultRateLimitBackoff = 30 * time.Second
+
+type breakerState byte
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// An upstream holds the state of an upstream host, shared by all endpoints
+// fetching from the same host.
+type upstream struct {
+	s         *Service
+	host      string
+	threshold int
+	openTime  time.Duration
+
+	mu           sync.Mutex
+	state        breakerState
+	failures     int
+	openUntil    time.Time
+	backoffUntil time.Time
+}
+
+// upstream returns the upstream for the host, creating it if it does not
+// exist.
+func (s *Service) upstream(host string) *upstream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.upstreams[host]; ok {
+		return u
+	}
+	u := &upstream{
+		s:    s,
+		host: host,
+	}
+	if cb := s.cfg.CircuitBreaker; cb != nil {
+		u.threshold = cb.FailureThreshold
+		u.openTime = time.Millisecond * time.Duration(cb.OpenTime)
+	}
+	if s.upstreams == nil {
+		s.upstreams = make(map[string]*upstream)
+	}
+	s.upstreams[host] = u
+	return u
+}
+
+// upstreamFor returns the upstream for the host of the url. If the url
+// cannot be parsed, the upstream for the empty host is returned.
+func (s *Service) upstreamFor(rawurl string) *upstream {
+	var host string
+	if u, err := url.Parse(rawurl); err == nil {
+		host = u.Host
+	}
+	return s.upstream(host)
+}
+
+// allow checks if a request may be made to the host. Returns an
+// unavailable error if the circuit breaker is open. Once the open time has
+// passed, a single request is allowed through to probe for recovery.
+func (u *upstream) allow() *res.Error {
+	if u.threshold == 0 {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	switch u.state {
+	case breakerOpen:
+		if time.Now().Before(u.openUntil) {
+			return u.unavailableError()
+		}
+		u.state = breakerHalfOpen
+		u.s.Debugf("Circuit breaker half-open for %s", u.host)
+	case breakerHalfOpen:
+		// A probe request is already in progress
+		return u.unavailableError()
+	}
+	return nil
+}
+
+// success records a successful request, closing the circuit breaker.
+func (u *upstream) success() {
+	if u.threshold == 0 {
+		return
+	}
+	u.mu.Lock()
+	if u.state != breakerClosed {
+		u.s.Logf("Circuit breaker closed for %s", u.host)
+	}
+	u.state = breakerClosed
+	u.failures = 0
+	u.mu.Unlock()
+}
+
+// failure records a failed request, opening the circuit breaker if the
+// failure threshold is reached, or if the request was a probe.
+func (u *upstream) failure() {
+	if u.threshold == 0 {
+		return
+	}
+	u.mu.Lock()
+	u.failures++
+	if u.state == breakerHalfOpen || (u.state == breakerClosed && u.failures >= u.threshold) {
+		u.state = breakerOpen
+		u.openUntil = time.Now().Add(u.openTime)
+		u.s.Logf("Circuit breaker open for %s after %d failures", u.host, u.failures)
+	}
+	u.mu.Unlock()
+}
+
+// canceled records a request canceled before it completed. If the request
+// was a probe, the circuit breaker is opened again without extending the
+// open time, so that the next request may probe instead.
+func (u *upstream) canceled() {
+	if u.threshold == 0 {
+		return
+	}
+	u.mu.Lock()
+	if u.state == breakerHalfOpen {
+		u.state = breakerOpen
+	}
+	u.mu.Unlock()
+}
+
+func (u *upstream) unavailableError() *res.Error {
+	return &res.Error{
+		Code:    CodeUnavailable,
+		Message: fmt.Sprintf("Upstream host %s is unavailable", u.host),
+	}
+}
+
+// checkRateLimit checks the response for rate limiting, and backs off
+// from the host as requested by the Retry-After header, or by the
+// rate limit headers if no requests remain.
+func (u *upstream) checkRateLimit(resp *http.Response) {
+	now := time.Now()
+	h := resp.Header
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		d, ok := retryAfter(h, now)
+		if !ok {
+			d, ok = rateLimitReset(h, now)
+		}
+		if !ok {
+			if resp.StatusCode != http.StatusTooManyRequests {
+				return
+			}
+			d = defaultRateLimitBackoff
+		}
+		u.backoff(now.Add(d))
+	default:
+		if remaining, ok := rateLimitRemaining(h); ok && remaining <= 0 {
+			if d, ok := rateLimitReset(h, now); ok {
+				u.backoff(now.Add(d))
+			}
+		}
+	}
+}
+
+// backoff makes requests to the host fail, and polls be delayed, until the
+// given time, unless already backing off for longer.
+func (u *upstream) backoff(until time.Time) {
+	u.mu.Lock()
+	if until.After(u.backoffUntil) {
+		u.backoffUntil = until
+		u.s.Logf("Backing off from %s for %s", u.host, until.Sub(time.Now()).Round(time.Millisecond))
+	}
+	u.mu.Unlock()
+}
+
+// backoffRemaining returns the remaining duration to back off from the
+// host, or 0 if not backing off.
+func (u *upstream) backoffRemaining() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	d := u.backoffUntil.Sub(time.Now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (u *upstream) rateLimitedError() *res.Error {
+	return &res.Error{
+		Code:    CodeRateLimited,
+		Message: fmt.Sprintf("Upstream host %s is rate limited", u.host),
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	res "github.com/jirenius/go-res"
+)
+
+func TestBreaker(t *testing.T) {
+	const (
+		allow = iota
+		deny
+		success
+		failure
+		canceled
+		expire // Pass the open time
+	)
+
+	tbl := []struct {
+		Steps    []int
+		Expected breakerState
+	}{
+		// Opens after threshold consecutive failures
+		{[]int{allow, failure, allow, failure, deny}, breakerOpen},
+		{[]int{allow, failure, allow, success, allow, failure, allow}, breakerClosed},
+		// Half-open probe after the open time
+		{[]int{failure, failure, expire, allow, deny}, breakerHalfOpen},
+		{[]int{failure, failure, expire, allow, success, allow, allow}, breakerClosed},
+		{[]int{failure, failure, expire, allow, failure, deny}, breakerOpen},
+		// Canceled probe lets another request probe
+		{[]int{failure, failure, expire, allow, canceled, allow, deny}, breakerHalfOpen},
+		{[]int{failure, failure, expire, allow, canceled, allow, success}, breakerClosed},
+		// Canceled requests are neutral when closed
+		{[]int{allow, failure, canceled, allow, failure, deny}, breakerOpen},
+	}
+
+	for i, l := range tbl {
+		u := &upstream{s: &Service{}, host: "example.com", threshold: 2, openTime: time.Hour}
+		for j, step := range l.Steps {
+			switch step {
+			case allow:
+				if rerr := u.allow(); rerr != nil {
+					t.Errorf("test %d, step %d: expected request to be allowed, but got %s", i, j, rerr.Message)
+				}
+			case deny:
+				if rerr := u.allow(); rerr == nil || rerr.Code != CodeUnavailable {
+					t.Errorf("test %d, step %d: expected unavailable error, but got %v", i, j, rerr)
+				}
+			case success:
+				u.success()
+			case failure:
+				u.failure()
+			case canceled:
+				u.canceled()
+			case expire:
+				u.openUntil = time.Now().Add(-time.Second)
+			}
+		}
+		if u.state != l.Expected {
+			t.Errorf("test %d: expected state %d, but got %d", i, l.Expected, u.state)
+		}
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	u := &upstream{s: &Service{}, host: "example.com"}
+	for i := 0; i < 10; i++ {
+		u.failure()
+	}
+	if rerr := u.allow(); rerr != nil {
+		t.Errorf("expected disabled breaker to allow requests, but got %s", rerr.Message)
+	}
+}
+
+func TestDoBackoffKeepsBreakerOpen(t *testing.T) {
+	s := &Service{}
+	ep := &endpoint{s: s}
+	u := &upstream{s: s, host: "example.com", threshold: 1, openTime: time.Hour}
+	u.failure()
+	u.openUntil = time.Now().Add(-time.Second)
+	u.backoff(time.Now().Add(time.Hour))
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	AssertNoError(t, err)
+	_, err = ep.do(u, req, priorityHigh)
+	if rerr, ok := err.(*res.Error); !ok || rerr.Code != CodeRateLimited {
+		t.Fatalf("expected rate limited error, but got %v", err)
+	}
+	if u.state != breakerOpen {
+		t.Errorf("expected breaker to stay open while backing off, but got state %d", u.state)
+	}
+}
+
+func TestDoCanceledProbe(t *testing.T) {
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	s := &Service{}
+	ep := &endpoint{s: s}
+	u := &upstream{s: s, host: "example.com", threshold: 1, openTime: time.Hour}
+	u.failure()
+	u.openUntil = time.Now().Add(-time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	AssertNoError(t, err)
+	if _, err = ep.do(u, req.WithContext(ctx), priorityHigh); err == nil {
+		t.Fatalf("expected canceled request to fail")
+	}
+	if u.state != breakerOpen {
+		t.Errorf("expected breaker to be open after canceled probe, but got state %d", u.state)
+	}
+	if rerr := u.allow(); rerr != nil {
+		t.Errorf("expected a new probe to be allowed, but got %s", rerr.Message)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tbl := []struct {
+		Status   int
+		Err      error
+		Expected bool
+	}{
+		{0, errors.New("connection refused"), true},
+		{0, &res.Error{Code: CodeUnavailable}, false},
+		{0, &res.Error{Code: CodeRateLimited}, false},
+		{http.StatusInternalServerError, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+		{http.StatusNotFound, nil, false},
+		{http.StatusTooManyRequests, nil, false},
+		{http.StatusOK, nil, false},
+	}
+
+	for i, l := range tbl {
+		var resp *http.Response
+		if l.Err == nil {
+			resp = &http.Response{StatusCode: l.Status}
+		}
+		if retryable := isRetryable(resp, l.Err); retryable != l.Expected {
+			t.Errorf("test %d: expected retryable to be %v, but got %v", i, l.Expected, retryable)
+		}
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	tbl := []struct {
+		Status   int // Status of the failing responses
+		Failures int32
+		Retries  int
+		Expected int
+		Calls    int32
+	}{
+		{http.StatusInternalServerError, 2, 3, http.StatusOK, 3},
+		{http.StatusBadGateway, 5, 2, http.StatusBadGateway, 3},
+		{http.StatusInternalServerError, 1, 0, http.StatusInternalServerError, 1},
+		{http.StatusNotFound, 1, 3, http.StatusNotFound, 1},
+	}
+
+	for i, l := range tbl {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= l.Failures {
+				w.WriteHeader(l.Status)
+				return
+			}
+			w.Write([]byte(`{}`))
+		}))
+
+		ep := &endpoint{s: &Service{}, retryDelay: time.Millisecond, maxRetryDelay: 2 * time.Millisecond}
+		resp, err := ep.fetchRetry(context.Background(), ts.URL, nil, l.Retries)
+		AssertNoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != l.Expected {
+			t.Errorf("test %d: expected status %d, but got %d", i, l.Expected, resp.StatusCode)
+		}
+		if n := atomic.LoadInt32(&calls); n != l.Calls {
+			t.Errorf("test %d: expected %d requests, but got %d", i, l.Calls, n)
+		}
+		ts.Close()
+	}
+}
+
+func TestFetchRetryCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	ep := &endpoint{s: &Service{}, retryDelay: time.Hour}
+	if _, err := ep.fetchRetry(ctx, ts.URL, nil, 3); err != context.Canceled {
+		t.Errorf("expected context canceled error, but got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A ConfigError is an error in the config, located by a JSON pointer.
+type ConfigError struct {
+	Pointer string
+	Message string
+}
+
+// ConfigErrors is a list of config errors.
+type ConfigErrors []*ConfigError
+
+// A Registration describes a resource pattern registered by the service.
+type Registration struct {
+	Pattern string // Resource ID pattern, including the service name
+	Type    string // Resource type, either model or collection
+	Pointer string // JSON pointer to the config of the resource
+}
+
+func (e *ConfigError) Error() string {
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return e.Pointer + ": " + e.Message
+}
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// configError returns the error as a config error located at the pointer,
+// unless it is already a config error.
+func configError(ptr string, err error) error {
+	if _, ok := err.(*ConfigError); ok {
+		return err
+	}
+	return &ConfigError{Pointer: ptr, Message: err.Error()}
+}
+
+// pointerToken escapes a JSON pointer reference token.
+func pointerToken(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// CheckConfigJSON strictly checks that the JSON encoded data can be
+// unmarshaled into v, which must be a pointer to a config struct, without
+// any unknown properties or values of the wrong type.
+func CheckConfigJSON(data []byte, v interface{}) ConfigErrors {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var i interface{}
+	if err := dec.Decode(&i); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			return ConfigErrors{{Message: fmt.Sprintf("%s at offset %d", serr, serr.Offset)}}
+		}
+		return ConfigErrors{{Message: err.Error()}}
+	}
+	var errs ConfigErrors
+	checkJSON(reflect.TypeOf(v), i, "", &errs)
+	return errs
+}
+
+func checkJSON(t reflect.Type, i interface{}, ptr string, errs *ConfigErrors) {
+	for t.Kind() == reflect.Ptr {
+		if i == nil {
+			return
+		}
+		t = t.Elem()
+	}
+	addErr := func(format string, v ...interface{}) {
+		*errs = append(*errs, &ConfigError{Pointer: ptr, Message: fmt.Sprintf(format, v...)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := i.(map[string]interface{})
+		if !ok {
+			addErr("expected an object")
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		jsonFields(t, fields)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kptr := ptr + "/" + pointerToken(k)
+			ft, ok := fields[k]
+			if !ok {
+				msg := fmt.Sprintf("unknown property %q", k)
+				for name := range fields {
+					if strings.EqualFold(name, k) {
+						msg += fmt.Sprintf(", did you mean %q?", name)
+						break
+					}
+				}
+				*errs = append(*errs, &ConfigError{Pointer: kptr, Message: msg})
+				continue
+			}
+			checkJSON(ft, obj[k], kptr, errs)
+		}
+	case reflect.Map:
+		if i == nil {
+			return
+		}
+		obj, ok := i.(map[string]interface{})
+		if !ok {
+			addErr("expected an object")
+			return
+		}
+		for k, e := range obj {
+			checkJSON(t.Elem(), e, ptr+"/"+pointerToken(k), errs)
+		}
+	case reflect.Slice:
+		if i == nil {
+			return
+		}
+		arr, ok := i.([]interface{})
+		if !ok {
+			addErr("expected an array")
+			return
+		}
+		for j, e := range arr {
+			checkJSON(t.Elem(), e, ptr+"/"+strconv.Itoa(j), errs)
+		}
+	case reflect.String:
+		if _, ok := i.(string); !ok {
+			addErr("expected a string")
+		}
+	case reflect.Bool:
+		if _, ok := i.(bool); !ok {
+			addErr("expected a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := i.(json.Number)
+		if !ok {
+			addErr("expected a number")
+			return
+		}
+		if _, err := strconv.ParseInt(string(n), 10, t.Bits()); err != nil {
+			addErr("expected an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := i.(json.Number); !ok {
+			addErr("expected a number")
+		}
+	}
+}
+
+// jsonFields adds the JSON property names and types of the fields of
+// struct type t, including the fields of embedded structs.
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for j := 0; j < t.NumField(); j++ {
+		f := t.Field(j)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
+
+// normalizePattern replaces all placeholders of a resource pattern with $,
+// so that patterns matching the same resource IDs are equal.
+func normalizePattern(pattern string) string {
+	tokens := strings.Split(pattern, btsep)
+	for i, t := range tokens {
+		if len(t) > 0 && t[0] == pmark {
+			tokens[i] = string(pmark)
+		}
+	}
+	return strings.Join(tokens, btsep)
+}
+
+// Registrations returns the resource patterns registered by the service,
+// in the order they were registered.
+func (s *Service) Registrations() []Registration {
+	return s.registrations
+}
+
+// ResetPatterns returns the patterns of the resource IDs reset by the
+// endpoints when the cached data is discarded.
+func (s *Service) ResetPatterns() []string {
+	var patterns []string
+	for _, ep := range s.endpoints {
+		patterns = append(patterns, ep.resetPatterns...)
+	}
+	return patterns
+}
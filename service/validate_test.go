@@ -0,0 +1,94 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestCheckConfigJSON(t *testing.T) {
+	tbl := []struct {
+		Data     string
+		Pointers []string
+	}{
+		{`{"serviceName":"foo","endpoints":[{"url":"http://x","type":"model","pattern":"foo"}]}`, nil},
+		{`{"fullAccess":true}`, []string{"/fullAccess"}},
+		{`{"endpoints":[{"url":"http://x","refreshTime":"5"}]}`, []string{"/endpoints/0/refreshTime"}},
+		{`{"endpoints":[{"refreshTime":1.5,"resources":[{"type":"model","idprop":"id"}]}]}`, []string{"/endpoints/0/refreshTime", "/endpoints/0/resources/0/idprop"}},
+		{`{"rateLimits":{"a/b":{"requests":true}}}`, []string{"/rateLimits/a~1b/requests"}},
+		{`{"endpoints":{}}`, []string{"/endpoints"}},
+		{`{"endpoints":[{"Access":{}}]}`, []string{"/endpoints/0/Access"}},
+		{`{"endpoints":[`, []string{""}},
+	}
+
+	for i, l := range tbl {
+		errs := CheckConfigJSON([]byte(l.Data), &Config{})
+		if len(errs) != len(l.Pointers) {
+			t.Errorf("test %d: expected %d errors, but got %d: %s", i, len(l.Pointers), len(errs), errs)
+			continue
+		}
+		for j, e := range errs {
+			if e.Pointer != l.Pointers[j] {
+				t.Errorf("test %d: expected error pointer %#v, but got %#v", i, l.Pointers[j], e.Pointer)
+			}
+		}
+	}
+}
+
+func TestNewServiceConfigErrors(t *testing.T) {
+	cfg := Config{Endpoints: []EndpointCfg{
+		{URL: "http://x/${a}", ResourceCfg: ResourceCfg{Type: "model", Pattern: "s.$a", Resources: []ResourceCfg{
+			{Type: "model", Path: "foo", Rename: map[string]string{"a": ""}},
+		}}},
+		{URL: "http://y/${b}", ResourceCfg: ResourceCfg{Type: "model", Pattern: "s.$b"}},
+		{URL: "http://z", ResourceCfg: ResourceCfg{Type: "model"}},
+	}}
+	cfg.SetDefault()
+	_, err := NewService(cfg)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, but got %#v", err)
+	}
+	expected := []string{"/endpoints/0/resources/0", "/endpoints/1", "/endpoints/2"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, but got %d: %s", len(expected), len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Pointer != expected[i] {
+			t.Errorf("expected error pointer %#v, but got %#v", expected[i], e.Pointer)
+		}
+	}
+}
+
+func TestNormalizePattern(t *testing.T) {
+	if normalizePattern("s.$a.foo.$b") != normalizePattern("s.$x.foo.$y") {
+		t.Errorf("expected patterns to be equal")
+	}
+	if normalizePattern("s.$a.foo") == normalizePattern("s.bar.foo") {
+		t.Errorf("expected patterns not to be equal")
+	}
+}
+
+func TestNewServiceNestedResourcePaths(t *testing.T) {
+	cfg := Config{ServiceName: "s", Endpoints: []EndpointCfg{
+		{URL: "http://x", ResourceCfg: ResourceCfg{Type: "model", Pattern: "root", Resources: []ResourceCfg{
+			{Type: "model", Path: "a", Resources: []ResourceCfg{
+				{Type: "model", Path: "b", Resources: []ResourceCfg{
+					{Type: "model", Path: "c"},
+				}},
+			}},
+		}}},
+	}}
+	cfg.SetDefault()
+	s, err := NewService(cfg)
+	AssertNoError(t, err)
+
+	var v value
+	AssertNoError(t, v.UnmarshalJSON([]byte(`{"a":{"b":{"c":{"d":1}}}}`)))
+	crs := make(map[string]cachedResource)
+	_, err = s.endpoints[0].traverse(crs, v, nil, nil)
+	AssertNoError(t, err)
+	for _, rid := range []string{"s.root", "s.root.a", "s.root.a.b", "s.root.a.b.c"} {
+		if _, ok := crs[rid]; !ok {
+			t.Errorf("expected resource %s to be set", rid)
+		}
+	}
+}
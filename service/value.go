@@ -3,6 +3,7 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 )
 
 type valueType byte
@@ -30,6 +31,7 @@ type value struct {
 	raw json.RawMessage
 	arr []value
 	obj map[string]value
+	xml bool // Decoded from an XML element
 }
 
 func (v *value) UnmarshalJSON(b []byte) error {
@@ -87,3 +89,80 @@ func (v value) MarshalJSON() ([]byte, error) {
 	}
 	return nil, errors.New("invalid value type")
 }
+
+// lookup returns the value found by following the path of object property
+// keys. The path is either a JSON pointer, eg. "/data/items", or a
+// dot-separated path, eg. "data.items". The bool is false if the path is
+// not found.
+func (v value) lookup(path string) (value, bool) {
+	for _, k := range splitPath(path) {
+		if v.typ != valueTypeObject {
+			return value{}, false
+		}
+		var ok bool
+		if v, ok = v.obj[k]; !ok {
+			return value{}, false
+		}
+	}
+	return v, true
+}
+
+// list returns the value as an array value, for data where a collection is
+// expected. As XML cannot tell a list of one element from a single element,
+// or an empty list from an empty element, a value decoded from an empty XML
+// element is returned as an empty array, and any other XML element as an
+// array of one element. The bool is false if the value cannot be made an
+// array.
+func (v value) list() (value, bool) {
+	if v.typ == valueTypeArray {
+		return v, true
+	}
+	if !v.xml {
+		return v, false
+	}
+	if v.isEmptyXML() {
+		return value{typ: valueTypeArray, arr: []value{}, xml: true}, true
+	}
+	return value{typ: valueTypeArray, arr: []value{v}, xml: true}, true
+}
+
+// isEmptyXML reports whether the value is decoded from an empty XML element.
+func (v value) isEmptyXML() bool {
+	return v.xml && v.typ == valueTypeString && string(v.raw) == `""`
+}
+
+// lookupList returns the array value found by following the path, as
+// returned by list. If an empty XML element is found along the path, an
+// empty array is returned. The bool is false if the path is not found, or
+// the value cannot be made an array.
+func (v value) lookupList(path string) (value, bool) {
+	for _, k := range splitPath(path) {
+		if v.isEmptyXML() {
+			break
+		}
+		if v.typ != valueTypeObject {
+			return value{}, false
+		}
+		var ok bool
+		if v, ok = v.obj[k]; !ok {
+			return value{}, false
+		}
+	}
+	return v.list()
+}
+
+// splitPath splits a JSON pointer or a dot-separated path into property
+// keys. An empty path, or the JSON pointer "/", results in no keys.
+func splitPath(path string) []string {
+	if path == "" || path == "/" {
+		return nil
+	}
+	if path[0] != '/' {
+		return strings.Split(path, btsep)
+	}
+	keys := strings.Split(path[1:], "/")
+	for i, k := range keys {
+		keys[i] = strings.Replace(strings.Replace(k, "~1", "/", -1), "~0", "~", -1)
+	}
+	return keys
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValueLookup(t *testing.T) {
+	var v value
+	AssertNoError(t, json.Unmarshal([]byte(`{"status":"ok","data":{"items":[1,2],"a/b":{"c~d":true}}}`), &v))
+
+	tbl := []struct {
+		Path     string
+		Expected valueType
+		OK       bool
+	}{
+		{"", valueTypeObject, true},
+		{"/", valueTypeObject, true},
+		{"status", valueTypeString, true},
+		{"data.items", valueTypeArray, true},
+		{"/data/items", valueTypeArray, true},
+		{"/data/a~1b/c~0d", valueTypeTrue, true},
+		{"data.missing", 0, false},
+		{"status.foo", 0, false},
+	}
+
+	for _, l := range tbl {
+		lv, ok := v.lookup(l.Path)
+		if ok != l.OK || (ok && lv.typ != l.Expected) {
+			t.Errorf("expected lookup of %q to return type %d, %t, but got %d, %t", l.Path, l.Expected, l.OK, lv.typ, ok)
+		}
+	}
+}
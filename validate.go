@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"./service"
+)
+
+var validateUsageStr = `
+Usage: rest2res validate [options]
+
+Validates the configuration file without connecting to NATS Server, and
+prints the resource patterns and reset patterns it would register.
+
+Validate Options:
+    -c, --config <file>              Configuration file (required)
+
+Common Options:
+    -h, --help                       Show this message
+`
+
+func validateUsage() {
+	fmt.Printf("%s\n", validateUsageStr)
+	os.Exit(0)
+}
+
+// validate runs the validate command.
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = validateUsage
+
+	var (
+		showHelp   bool
+		configFile string
+	)
+	fs.BoolVar(&showHelp, "h", false, "Show this message.")
+	fs.BoolVar(&showHelp, "help", false, "Show this message.")
+	fs.StringVar(&configFile, "c", "", "Configuration file.")
+	fs.StringVar(&configFile, "config", "", "Configuration file.")
+
+	if err := fs.Parse(args); err != nil {
+		printAndDie(fmt.Sprintf("error parsing arguments: %s", err.Error()), false)
+	}
+	if showHelp {
+		validateUsage()
+	}
+	if configFile == "" {
+		fmt.Fprintf(os.Stderr, "missing config file\n%s\n", validateUsageStr)
+		os.Exit(1)
+	}
+
+	fin, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		printAndDie(fmt.Sprintf("error loading config file: %s", err), false)
+	}
+
+	var cfg Config
+	if errs := service.CheckConfigJSON(fin, &cfg); len(errs) > 0 {
+		printConfigErrors(configFile, errs)
+	}
+	if err = json.Unmarshal(fin, &cfg); err != nil {
+		printAndDie(fmt.Sprintf("error parsing config file: %s", err), false)
+	}
+	cfg.SetDefault()
+
+	if _, err := cfg.Nats.Options(); err != nil {
+		printConfigErrors(configFile, service.ConfigErrors{{Pointer: "/nats", Message: err.Error()}})
+	}
+
+	s, err := service.NewService(cfg.Config)
+	if err != nil {
+		if errs, ok := err.(service.ConfigErrors); ok {
+			printConfigErrors(configFile, errs)
+		}
+		printAndDie(err.Error(), false)
+	}
+
+	fmt.Printf("Resources:\n")
+	printPatternTree(s.Registrations())
+	fmt.Printf("\nReset patterns:\n")
+	for _, p := range s.ResetPatterns() {
+		fmt.Printf("    %s\n", p)
+	}
+	fmt.Printf("\n%s is valid\n", configFile)
+}
+
+func printConfigErrors(configFile string, errs service.ConfigErrors) {
+	for _, e := range errs {
+		ptr := e.Pointer
+		if ptr == "" {
+			ptr = "/"
+		}
+		fmt.Fprintf(os.Stderr, "%s#%s: %s\n", configFile, ptr, e.Message)
+	}
+	os.Exit(1)
+}
+
+// patternNode is a node in the tree of resource pattern tokens.
+type patternNode struct {
+	reg   *service.Registration
+	nodes map[string]*patternNode
+}
+
+// printPatternTree prints the registered resource patterns as a tree of
+// pattern tokens.
+func printPatternTree(regs []service.Registration) {
+	root := &patternNode{}
+	for i := range regs {
+		n := root
+		for _, t := range strings.Split(regs[i].Pattern, ".") {
+			if n.nodes == nil {
+				n.nodes = make(map[string]*patternNode)
+			}
+			next := n.nodes[t]
+			if next == nil {
+				next = &patternNode{}
+				n.nodes[t] = next
+			}
+			n = next
+		}
+		n.reg = &regs[i]
+	}
+	root.print(1)
+}
+
+func (n *patternNode) print(depth int) {
+	tokens := make([]string, 0, len(n.nodes))
+	for t := range n.nodes {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+	for _, t := range tokens {
+		c := n.nodes[t]
+		line := strings.Repeat("    ", depth) + t
+		if c.reg != nil {
+			line = fmt.Sprintf("%-48s %-10s %s", line, c.reg.Type, c.reg.Pointer)
+		}
+		fmt.Println(line)
+		c.print(depth + 1)
+	}
+}